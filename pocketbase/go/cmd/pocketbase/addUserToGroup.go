@@ -44,7 +44,7 @@ func addUserToGroup(e *core.RequestEvent) error {
 
 	memberCollection, err := app.FindCollectionByNameOrId("members")
 	if err != nil {
-		log.Error("[ADD_USER] Failed to find members collection: %v", err.Error())
+		log.Error("[ADD_USER] Failed to find members collection", "err", err)
 		return e.JSON(500, errorJSON("Failed to find members collection"))
 	}
 
@@ -53,7 +53,7 @@ func addUserToGroup(e *core.RequestEvent) error {
 	memberRecord.Set("group", groupId)
 
 	if err := app.Save(memberRecord); err != nil {
-		log.Error("Failed to add user to group: %v", err.Error())
+		log.Error("Failed to add user to group", "err", err)
 		return e.JSON(500, errorJSON("Failed to add user to group: %v", err.Error()))
 	}
 
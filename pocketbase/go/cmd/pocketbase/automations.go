@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/search"
+)
+
+// Une automation (collection automations) crée un job quand un record d'un groupe est
+// créé, modifié ou supprimé dans une des automationCollections, si le record correspond au filtre.
+// L'input du job est un template JSON : "{{record.id}}" est remplacé par la valeur (type conservé),
+// "media {{record.name}}" par son texte. Variables : record, event, collection, group, automation.
+
+// automationCollections sont les collections qui peuvent déclencher une automation
+var automationCollections = []string{"medias", "contents", "devices"}
+
+// automationVarPattern repère les variables d'un template ({{record.data.key}})
+var automationVarPattern = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+// automationDeletes garde les automations correspondant à un record supprimé :
+// le filtre est évalué avant la suppression, les jobs sont créés après son succès
+var automationDeletes sync.Map // record id => []*core.Record
+
+// automationFilterExpr construit l'expression SQL du filtre d'une automation.
+// Les macros @request et @collection et les champs cachés sont refusés (pas d'accès hors du record).
+func automationFilterExpr(app core.App, collection *core.Collection, filter string) (dbx.Expression, *core.RecordFieldResolver, error) {
+	if strings.Contains(filter, "@request") || strings.Contains(filter, "@collection") {
+		return nil, nil, errors.New("invalid filter: @request and @collection are not allowed")
+	}
+
+	resolver := core.NewRecordFieldResolver(app, collection, nil, false)
+
+	expr, err := search.FilterData(filter).BuildExpr(resolver)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	return expr, resolver, nil
+}
+
+// matchAutomation indique si le record (encore en base) correspond au filtre de l'automation
+func matchAutomation(app core.App, automation *core.Record, record *core.Record) (bool, error) {
+	filter := automation.GetString("filter")
+	if filter == "" {
+		return true, nil
+	}
+
+	collection := record.Collection()
+
+	expr, resolver, err := automationFilterExpr(app, collection, filter)
+	if err != nil {
+		return false, err
+	}
+
+	query := app.RecordQuery(collection).
+		Select("(1)").
+		AndWhere(dbx.HashExp{collection.Name + ".id": record.Id}).
+		AndWhere(expr).
+		Limit(1)
+
+	if err := resolver.UpdateQuery(query); err != nil {
+		return false, err
+	}
+
+	var found int
+	if err := query.Row(&found); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// findAutomations retourne les automations actives du groupe du record pour un événement
+// (create, update ou delete) qui correspondent au record
+func findAutomations(app core.App, record *core.Record, event string) []*core.Record {
+	group := record.GetString("group")
+	if group == "" {
+		return nil
+	}
+
+	automations, err := app.FindAllRecords("automations", dbx.HashExp{
+		"enabled":    true,
+		"collection": record.Collection().Name,
+		"event":      event,
+		"group":      group,
+	})
+	if err != nil {
+		app.Logger().Error("❌ failed to load automations", "err", err)
+		return nil
+	}
+
+	matched := make([]*core.Record, 0, len(automations))
+	for _, automation := range automations {
+		ok, err := matchAutomation(app, automation, record)
+		if err != nil {
+			app.Logger().Warn("⚠️ automation filter failed", "automation", automation.Id, "err", err)
+			continue
+		}
+		if ok {
+			matched = append(matched, automation)
+		}
+	}
+
+	return matched
+}
+
+// automationValue retourne la valeur d'une variable (chemin pointé) d'un template
+func automationValue(vars map[string]any, path string) any {
+	var value any = vars
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// renderAutomationInput remplace les variables du template d'input
+func renderAutomationInput(template any, vars map[string]any) any {
+	switch v := template.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = renderAutomationInput(item, vars)
+		}
+		return out
+
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = renderAutomationInput(item, vars)
+		}
+		return out
+
+	case string:
+		// variable seule : la valeur garde son type (nombre, objet, ...)
+		if m := automationVarPattern.FindStringSubmatch(v); m != nil && m[0] == v {
+			return automationValue(vars, m[1])
+		}
+		return automationVarPattern.ReplaceAllStringFunc(v, func(match string) string {
+			value := automationValue(vars, automationVarPattern.FindStringSubmatch(match)[1])
+			if value == nil {
+				return ""
+			}
+			if s, ok := value.(string); ok {
+				return s
+			}
+			raw, _ := json.Marshal(value)
+			return string(raw)
+		})
+	}
+
+	return template
+}
+
+// runAutomation crée le job d'une automation déclenchée par un record.
+// La clé d'idempotence (automation, record, événement) évite qu'un job qui modifie
+// le record déclencheur ne relance l'automation en boucle. Pour une modification, seul un job
+// en attente ou en cours bloque : chaque modification ultérieure du record déclenche l'automation.
+func runAutomation(app *pocketbase.PocketBase, automation *core.Record, record *core.Record, event string) {
+	logger := app.Logger()
+	group := automation.GetString("group")
+
+	// record exporté en JSON pour naviguer dans ses champs (data.key, ...)
+	var exported map[string]any
+	raw, _ := json.Marshal(record.PublicExport())
+	json.Unmarshal(raw, &exported)
+
+	var template any
+	automation.UnmarshalJSONField("input", &template)
+
+	input := renderAutomationInput(template, map[string]any{
+		"record":     exported,
+		"event":      event,
+		"collection": record.Collection().Name,
+		"group":      group,
+		"automation": automation.Id,
+	})
+
+	key := fmt.Sprintf("automation:%s:%s:%s", automation.Id, record.Id, event)
+
+	window := envDuration("JOB_IDEMPOTENCY_WINDOW", defaultIdempotencyWindow)
+	if event == "update" {
+		window = 0
+	}
+
+	jobCreateMu.Lock()
+	job, err := createAutomationJob(app, automation, input, key, window)
+	jobCreateMu.Unlock()
+
+	if job == nil && err == nil {
+		logger.Info("♻️ automation already running", "automation", automation.Id, "record", record.Id, "event", event)
+		return
+	}
+
+	automation.Set("lastRun", time.Now())
+	if err != nil {
+		logger.Error("❌ automation job creation failed", "automation", automation.Id, "record", record.Id, "err", err)
+		automation.Set("lastError", err.Error())
+	} else {
+		logger.Info("⚡ automation job created", "automation", automation.Id, "record", record.Id, "event", event, "job", job.Id)
+		automation.Set("lastJob", job.Id)
+		automation.Set("lastError", "")
+	}
+
+	if err := app.Save(automation); err != nil {
+		logger.Error("❌ automation save failed", "automation", automation.Id, "err", err)
+	}
+}
+
+// createAutomationJob crée le job d'une automation
+// (nil sans erreur si un job avec la même clé existe déjà, voir findJobByKey)
+func createAutomationJob(app *pocketbase.PocketBase, automation *core.Record, input any, key string, window time.Duration) (*core.Record, error) {
+	group := automation.GetString("group")
+
+	if existing := findJobByKey(app, group, key, window); existing != nil {
+		return nil, nil
+	}
+
+	collection, err := app.FindCollectionByNameOrId("jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	job := core.NewRecord(collection)
+	job.Set("action", automation.GetString("action"))
+	job.Set("group", group)
+	job.Set("input", input)
+	job.Set("idempotencyKey", key)
+
+	if err := validateJob(job); err != nil {
+		return nil, err
+	}
+
+	if err := app.Save(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// dispatchAutomations crée les jobs des automations correspondant au record
+func dispatchAutomations(app *pocketbase.PocketBase, automations []*core.Record, record *core.Record, event string) {
+	for _, automation := range automations {
+		runAutomation(app, automation, record, event)
+	}
+}
+
+// prepareAutomation valide le filtre d'une automation sur sa collection (hook de requête create/update)
+func prepareAutomation(e *core.RecordRequestEvent) error {
+	collection, err := e.App.FindCollectionByNameOrId(e.Record.GetString("collection"))
+	if err != nil || !containsString(automationCollections, collection.Name) {
+		return e.JSON(400, errorJSON("Invalid collection"))
+	}
+
+	if filter := e.Record.GetString("filter"); filter != "" {
+		if _, _, err := automationFilterExpr(e.App, collection, filter); err != nil {
+			return e.JSON(400, errorJSON("%s", err.Error()))
+		}
+	}
+
+	return e.Next()
+}
+
+// bindAutomations déclenche les automations des groupes sur les créations,
+// modifications et suppressions de records
+func bindAutomations(app *pocketbase.PocketBase) {
+	app.OnRecordCreateRequest("automations").BindFunc(prepareAutomation)
+	app.OnRecordUpdateRequest("automations").BindFunc(prepareAutomation)
+
+	app.OnRecordAfterCreateSuccess(automationCollections...).BindFunc(func(e *core.RecordEvent) error {
+		dispatchAutomations(app, findAutomations(e.App, e.Record, "create"), e.Record, "create")
+		return e.Next()
+	})
+
+	app.OnRecordAfterUpdateSuccess(automationCollections...).BindFunc(func(e *core.RecordEvent) error {
+		dispatchAutomations(app, findAutomations(e.App, e.Record, "update"), e.Record, "update")
+		return e.Next()
+	})
+
+	// le filtre est évalué tant que le record existe encore
+	app.OnRecordDelete(automationCollections...).BindFunc(func(e *core.RecordEvent) error {
+		if automations := findAutomations(e.App, e.Record, "delete"); len(automations) > 0 {
+			automationDeletes.Store(e.Record.Id, automations)
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterDeleteSuccess(automationCollections...).BindFunc(func(e *core.RecordEvent) error {
+		if automations, ok := automationDeletes.LoadAndDelete(e.Record.Id); ok {
+			dispatchAutomations(app, automations.([]*core.Record), e.Record, "delete")
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterDeleteError(automationCollections...).BindFunc(func(e *core.RecordErrorEvent) error {
+		automationDeletes.Delete(e.Record.Id)
+		return e.Next()
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// cancelJob stops a running job (killing its process) or removes it from the queue
+func cancelJob(e *core.RequestEvent) error {
+	app := e.App
+	jobId := e.Request.PathValue("id")
+
+	log := app.Logger()
+
+	job, err := app.FindRecordById("jobs", jobId)
+	if err != nil {
+		return e.JSON(404, errorJSON("Job not found"))
+	}
+
+	// Same role as the jobs update rule
+	if err := checkPermission(e, job.GetString("group"), 20); err != nil {
+		return err
+	}
+
+	status := job.GetString("status")
+	if status != "pending" && status != "processing" {
+		return e.JSON(400, errorJSON("Job is not pending or processing: %s", status))
+	}
+
+	// Not started yet: take it out of the queue
+	jobQueue.remove(job.Id)
+
+	// Running: the job kills its process, then marks itself as cancelled with its partial logs
+	if jobQueue.cancel(job.Id) {
+		log.Info("Job cancel requested", "id", job.Id)
+		return e.JSON(202, map[string]any{
+			"id":     job.Id,
+			"status": status,
+		})
+	}
+
+	job.Set("status", "cancelled")
+	job.Set("error", "Cancelled")
+
+	if err := app.Save(job); err != nil {
+		log.Error("Failed to cancel job", "id", job.Id, "err", err)
+		return e.JSON(500, errorJSON("Failed to cancel job"))
+	}
+
+	log.Info("Job cancelled", "id", job.Id)
+	return e.JSON(200, map[string]any{
+		"id":     job.Id,
+		"status": "cancelled",
+	})
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"errors"
+
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// errPermissionDenied is returned once the error response has been written,
+// so that the calling handler stops processing the request
+var errPermissionDenied = errors.New("permission denied")
+
 // checkGroupPermission verifies that the authenticated user has the required role in the specified group
 func checkPermission(e *core.RequestEvent, groupId string, minRole int) error {
 	app := e.App
@@ -12,7 +18,7 @@ func checkPermission(e *core.RequestEvent, groupId string, minRole int) error {
 
 	if auth == nil {
 		log.Warn("authentication required")
-		return e.JSON(401, errorJSON("authentication required"))
+		return denyPermission(e, 401, errorJSON("authentication required"))
 	}
 
 	// Check if user is member of the group with the required role
@@ -21,15 +27,23 @@ func checkPermission(e *core.RequestEvent, groupId string, minRole int) error {
 		"group": groupId,
 	})
 	if err != nil {
-		log.Warn("user %s is not a member of group %s", auth.Id, groupId)
-		return e.JSON(403, errorJSON("user is not a member of group %s", groupId))
+		log.Warn("user is not a member of group", "user", auth.Id, "group", groupId)
+		return denyPermission(e, 403, errorJSON("user is not a member of group %s", groupId))
 	}
 
 	userRole := member.GetInt("role")
 	if userRole < minRole {
-		log.Warn("insufficient permissions. user %s group %s role %d", auth.Id, groupId, minRole)
-		return e.JSON(403, errorJSON("insufficient permissions"))
+		log.Warn("insufficient permissions", "user", auth.Id, "group", groupId, "minRole", minRole)
+		return denyPermission(e, 403, errorJSON("insufficient permissions"))
 	}
 
 	return nil
 }
+
+// denyPermission writes the error response and returns errPermissionDenied
+func denyPermission(e *core.RequestEvent, status int, data any) error {
+	if err := e.JSON(status, data); err != nil {
+		return err
+	}
+	return errPermissionDenied
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Un transcodage DASH : les renditions de l'échelle en MP4 fragmenté (segment d'init et segments .m4s
+// par représentation) dans un seul adaptation set vidéo, l'audio dans un second, et le manifeste
+// manifest.mpd, tous écrits par ffmpeg en un seul passage
+
+// probeHasAudio indique si le fichier source contient une piste audio
+// (sans audio, l'adaptation set audio ferait échouer ffmpeg). L'annulation de ctx arrête ffprobe.
+func probeHasAudio(ctx context.Context, inputPath string) bool {
+	output, err := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", inputPath).Output()
+	if err != nil {
+		return false
+	}
+
+	var probeOutput TranscodeFFProbeOutput
+	if err := json.Unmarshal(output, &probeOutput); err != nil {
+		return false
+	}
+
+	for _, stream := range probeOutput.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// dashArgs construit la commande ffmpeg de l'échelle DASH : une représentation vidéo par rendition
+// (même flux source mis à l'échelle) et l'audio au débit du profil demandé
+func dashArgs(ctx context.Context, inputPath, outputDir string, profile TranscodeProfile) []string {
+	ladder := transcodeLadder(profile)
+	audio := probeHasAudio(ctx, inputPath)
+
+	args := []string{"-y", "-progress", "pipe:2", "-i", inputPath}
+
+	for range ladder {
+		args = append(args, "-map", "0:v:0")
+	}
+	if audio {
+		args = append(args, "-map", "0:a:0")
+	}
+
+	args = append(args, "-c:v", "libx264")
+	for i, rung := range ladder {
+		stream := strconv.Itoa(i)
+		args = append(args,
+			"-preset:v:"+stream, rung.Preset,
+			"-crf:v:"+stream, strconv.Itoa(rung.CRF),
+			"-maxrate:v:"+stream, rung.Bitrate,
+			"-bufsize:v:"+stream, fmt.Sprintf("%dk", 2*bitrateKbps(rung.Bitrate)),
+			"-filter:v:"+stream, scaleFilter(rung),
+		)
+	}
+	args = append(args, "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", packagedSegmentSeconds))
+
+	adaptationSets := "id=0,streams=v"
+	if audio {
+		args = append(args, "-c:a", "aac", "-b:a", profile.AudioRate)
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(packagedSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+		filepath.Join(outputDir, supportedFormats["DASH"].Manifest),
+	)
+
+	return args
+}
+
+// dashHandler sert le manifeste et les segments DASH d'un media, pour un profil
+// Route: /api/medias/{id}/dash/{profile}/{file}
+func dashHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		return servePackagedFile(e, supportedFormats["DASH"])
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString retourne la variable d'environnement name, ou def si elle est absente
+func envString(name string, def string) string {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value
+	}
+	return def
+}
+
+// envInt retourne la variable d'environnement name convertie en entier, ou def si absente ou invalide
+func envInt(name string, def int) int {
+	if value, err := strconv.Atoi(envString(name, "")); err == nil {
+		return value
+	}
+	return def
+}
+
+// envBool retourne la variable d'environnement name en booléen ("true", "1"...), ou def si absente ou invalide
+func envBool(name string, def bool) bool {
+	if value, err := strconv.ParseBool(envString(name, "")); err == nil {
+		return value
+	}
+	return def
+}
+
+// envDuration retourne la variable d'environnement name en durée ("30s", "5m"), ou def si absente ou invalide
+func envDuration(name string, def time.Duration) time.Duration {
+	if value, err := time.ParseDuration(envString(name, "")); err == nil {
+		return value
+	}
+	return def
+}
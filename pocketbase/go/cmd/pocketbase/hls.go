@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Un transcodage HLS : une playlist et ses segments MPEG-TS par rendition (écrits par ffmpeg),
+// et la playlist maître master.m3u8 (écrite par writeHLSMaster)
+
+// hlsArgs construit la commande ffmpeg de l'échelle HLS : une sortie par rendition, en un seul passage
+func hlsArgs(inputPath, outputDir string, profile TranscodeProfile) []string {
+	args := []string{"-y", "-progress", "pipe:2", "-i", inputPath}
+
+	for _, rung := range transcodeLadder(profile) {
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", rung.Preset,
+			"-crf", strconv.Itoa(rung.CRF),
+			"-maxrate", rung.Bitrate,
+			"-bufsize", fmt.Sprintf("%dk", 2*bitrateKbps(rung.Bitrate)),
+			"-vf", scaleFilter(rung),
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", packagedSegmentSeconds),
+			"-c:a", "aac",
+			"-b:a", rung.AudioRate,
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(packagedSegmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(outputDir, rung.Name+"_%03d.ts"),
+			filepath.Join(outputDir, rung.Name+".m3u8"),
+		)
+	}
+
+	return args
+}
+
+// writeHLSMaster écrit la playlist maître de l'échelle (ffmpeg a écrit les playlists des renditions)
+func writeHLSMaster(outputDir string, profile TranscodeProfile, manifest string) error {
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+
+	for _, rung := range transcodeLadder(profile) {
+		if _, err := os.Stat(filepath.Join(outputDir, rung.Name+".m3u8")); err != nil {
+			return fmt.Errorf("missing %s playlist: %w", rung.Name, err)
+		}
+
+		bandwidth := (bitrateKbps(rung.Bitrate) + bitrateKbps(rung.AudioRate)) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s.m3u8\n", bandwidth, rung.Width, rung.Height, rung.Name)
+	}
+
+	return os.WriteFile(filepath.Join(outputDir, manifest), []byte(master.String()), 0o644)
+}
+
+// hlsHandler sert la playlist maître, les playlists et les segments HLS d'un media, pour un profil
+// Route: /api/medias/{id}/hls/{profile}/{file}
+func hlsHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		return servePackagedFile(e, supportedFormats["HLS"])
+	}
+}
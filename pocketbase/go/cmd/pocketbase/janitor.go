@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const janitorBatch = 500 // Records traités par collection et par statut à chaque passage
+
+// janitorCollections sont les collections purgées par le janitor
+var janitorCollections = []string{"jobs", "transcodes"}
+
+// defaultRetention est la durée de conservation par statut, en jours (RETENTION_<STATUS>).
+// "deleted" est le délai entre la suppression logique et la suppression définitive.
+var defaultRetention = map[string]int{
+	"finished":  30,
+	"failed":    90,
+	"cancelled": 30,
+	"skipped":   30,
+	"deleted":   1,
+}
+
+// pinnedRetention sont les statuts d'une collection conservés indéfiniment, sauf si
+// RETENTION_<COLLECTION>_<STATUS> est défini explicitement (ni RETENTION_<STATUS> ni la conservation
+// du groupe ne s'y appliquent) : un transcode terminé est le rendu servi d'un media, pas un historique
+var pinnedRetention = map[string][]string{
+	"transcodes": {"finished"},
+}
+
+// JanitorReport résume ce que le janitor a supprimé
+type JanitorReport struct {
+	SoftDeleted int   `json:"softDeleted"` // records passés au statut deleted
+	Deleted     int   `json:"deleted"`     // records supprimés définitivement
+	Files       int   `json:"files"`       // fichiers stockés supprimés
+	Bytes       int64 `json:"bytes"`       // taille des fichiers supprimés
+	Logs        int64 `json:"logs"`        // lignes de job_logs supprimées (cascade)
+}
+
+// janitorMu évite deux passages simultanés (cron et route manuelle)
+var janitorMu sync.Mutex
+
+// retentionDays retourne la conservation d'un statut pour une collection :
+// RETENTION_<COLLECTION>_<STATUS>, puis RETENTION_<STATUS>, puis la valeur par défaut. 0 = jamais purgé.
+func retentionDays(collection string, status string) int {
+	if isPinnedRetention(collection, status) {
+		return envInt("RETENTION_"+strings.ToUpper(collection)+"_"+strings.ToUpper(status), 0)
+	}
+
+	days := envInt("RETENTION_"+strings.ToUpper(status), defaultRetention[status])
+	return envInt("RETENTION_"+strings.ToUpper(collection)+"_"+strings.ToUpper(status), days)
+}
+
+// isPinnedRetention indique si un statut d'une collection est conservé par défaut (pinnedRetention)
+func isPinnedRetention(collection string, status string) bool {
+	return containsString(pinnedRetention[collection], status)
+}
+
+// groupRetentions retourne les conservations propres aux groupes (champ retention : {"finished": 7, ...})
+func groupRetentions(app *pocketbase.PocketBase) map[string]map[string]int {
+	groups, err := app.FindAllRecords("groups")
+	if err != nil {
+		app.Logger().Error("❌ failed to load group retentions", "err", err)
+		return nil
+	}
+
+	retentions := map[string]map[string]int{}
+	for _, group := range groups {
+		if group.GetString("retention") == "" {
+			continue
+		}
+
+		retention := map[string]int{}
+		if err := group.UnmarshalJSONField("retention", &retention); err != nil {
+			app.Logger().Warn("⚠️ invalid group retention", "group", group.Id, "err", err)
+			continue
+		}
+		if len(retention) > 0 {
+			retentions[group.Id] = retention
+		}
+	}
+
+	return retentions
+}
+
+// expiredRecords retourne les records d'un statut non modifiés depuis days jours,
+// d'un groupe (group != "") ou de tous les groupes sauf exclude
+func expiredRecords(app *pocketbase.PocketBase, collection string, status string, days int, group string, exclude []string) []*core.Record {
+	params := map[string]any{
+		"status": status,
+		"before": time.Now().AddDate(0, 0, -days).UTC().Format(types.DefaultDateLayout),
+	}
+
+	filter := "status = {:status} && updated < {:before}"
+	if group != "" {
+		filter += " && group = {:group}"
+		params["group"] = group
+	}
+	for i, id := range exclude {
+		filter += fmt.Sprintf(" && group != {:exclude%d}", i)
+		params[fmt.Sprintf("exclude%d", i)] = id
+	}
+
+	records, err := app.FindRecordsByFilter(collection, filter, "updated", janitorBatch, 0, params)
+	if err != nil {
+		app.Logger().Error("❌ failed to load expired records", "collection", collection, "status", status, "err", err)
+		return nil
+	}
+
+	return records
+}
+
+// isJobInUse indique si un job terminé est encore nécessaire (retry prévu, dépendance d'un job actif)
+func isJobInUse(app *pocketbase.PocketBase, job *core.Record) bool {
+	if job.GetString("status") == "failed" && canRetryJob(job) {
+		return true
+	}
+
+	dependent, _ := app.FindFirstRecordByFilter(
+		"jobs",
+		"dependsOn:each ?= {:id} && (status = '' || status = 'pending' || status = 'processing')",
+		map[string]any{"id": job.Id},
+	)
+
+	return dependent != nil
+}
+
+// purgeRecord supprime définitivement un record et ses fichiers stockés, en comptant ce qui est libéré
+func purgeRecord(app *pocketbase.PocketBase, fsys *filesystem.System, record *core.Record, report *JanitorReport) error {
+	var files int
+	var bytes int64
+
+	for _, field := range record.Collection().Fields {
+		if field.Type() != core.FieldTypeFile {
+			continue
+		}
+		for _, name := range record.GetStringSlice(field.GetName()) {
+			files++
+			if attrs, err := fsys.Attributes(record.BaseFilesPath() + "/" + name); err == nil {
+				bytes += attrs.Size
+			}
+		}
+	}
+
+	var logs int64
+	if record.Collection().Name == "jobs" {
+		logs, _ = app.CountRecords("job_logs", dbx.HashExp{"job": record.Id})
+	}
+
+	// les fichiers sont supprimés par PocketBase avec le record, les job_logs en cascade
+	if err := app.Delete(record); err != nil {
+		return err
+	}
+
+	report.Deleted++
+	report.Files += files
+	report.Bytes += bytes
+	report.Logs += logs
+
+	return nil
+}
+
+// runJanitor applique la politique de conservation : suppression logique (statut deleted) des records
+// expirés, puis suppression définitive des records deleted depuis RETENTION_DELETED jours
+func runJanitor(app *pocketbase.PocketBase) JanitorReport {
+	janitorMu.Lock()
+	defer janitorMu.Unlock()
+
+	logger := app.Logger()
+	report := JanitorReport{}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		logger.Error("❌ janitor filesystem error", "err", err)
+		return report
+	}
+	defer fsys.Close()
+
+	groups := groupRetentions(app)
+
+	// groupes dont la conservation d'un statut est redéfinie
+	overrides := func(status string) []string {
+		ids := []string{}
+		for id, retention := range groups {
+			if _, ok := retention[status]; ok {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	for _, collection := range janitorCollections {
+		for status := range defaultRetention {
+			exclude := overrides(status)
+			if isPinnedRetention(collection, status) {
+				exclude = nil
+			}
+
+			var records []*core.Record
+			if days := retentionDays(collection, status); days > 0 {
+				records = expiredRecords(app, collection, status, days, "", exclude)
+			}
+			for _, id := range exclude {
+				if days := groups[id][status]; days > 0 {
+					records = append(records, expiredRecords(app, collection, status, days, id, nil)...)
+				}
+			}
+
+			for _, record := range records {
+				if status == "deleted" {
+					if err := purgeRecord(app, fsys, record, &report); err != nil {
+						logger.Error("❌ janitor delete failed", "collection", collection, "id", record.Id, "err", err)
+					}
+					continue
+				}
+
+				if collection == "jobs" && isJobInUse(app, record) {
+					continue
+				}
+
+				record.Set("status", "deleted")
+				if err := app.Save(record); err != nil {
+					logger.Error("❌ janitor soft delete failed", "collection", collection, "id", record.Id, "err", err)
+					continue
+				}
+				report.SoftDeleted++
+			}
+		}
+	}
+
+	if report.SoftDeleted > 0 || report.Deleted > 0 {
+		logger.Info("🧹 janitor",
+			"softDeleted", report.SoftDeleted,
+			"deleted", report.Deleted,
+			"files", report.Files,
+			"bytes", report.Bytes,
+			"logs", report.Logs,
+		)
+	}
+
+	return report
+}
+
+// bindJanitor purge chaque heure les jobs et transcodes terminés selon la politique de conservation
+func bindJanitor(app *pocketbase.PocketBase) {
+	app.Cron().MustAdd("janitor", envString("JANITOR_CRON", "17 * * * *"), func() {
+		runJanitor(app)
+	})
+
+	// Passage manuel (superusers), retourne le rapport
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		se.Router.POST("/api/janitor/run", func(e *core.RequestEvent) error {
+			return e.JSON(200, runJanitor(app))
+		}).Bind(apis.RequireSuperuserAuth())
+
+		return se.Next()
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// JobAction décrit la configuration d'une action de job
+type JobAction struct {
+	MaxAttempts int // Nombre total de tentatives (1 = pas de retry)
+	MaxParallel int // Max d'exécutions simultanées de l'action, tous groupes confondus (0 = illimité)
+	Priority    int // Priorité des jobs de l'action, tous groupes confondus (la plus haute démarre en premier)
+
+	Timeout    time.Duration // Durée maximale d'une exécution (0 = JOB_TIMEOUT)
+	Inactivity time.Duration // Durée maximale sans progress, result ni log (0 = JOB_INACTIVITY)
+	RetryDelay time.Duration // Délai de base du backoff entre tentatives (0 = JOB_RETRY_BASE_DELAY)
+	Limits     JobLimits     // Limites de ressources des process du job
+
+	Remote  bool     // Exécutée uniquement par les workers distants (commande worker), jamais localement
+	Secrets []string // Secrets du groupe injectés en variables d'environnement (manifest)
+	Env     []string // Variables du serveur transmises aux process (liste de la permission env du manifest)
+
+	// Manifest jobs/<action>.json (schéma d'input, permissions, timeout, retry), nil si absent
+	Manifest *JobManifest
+
+	// Runner de l'action. Par défaut (nil) : script jobs/<action>.ts exécuté par Deno,
+	// ou à défaut exécutable jobs/<action>. Tous respectent le même protocole (voir JobContext).
+	Runner JobRunner
+}
+
+// Configuration par défaut des actions, surchargeable par variable d'environnement
+// (ex: JOB_ODOO_MAX_ATTEMPTS=5, JOB_ODOO_MAX_PARALLEL=1, JOB_ODOO_PRIORITY=-10)
+var jobActions = map[string]JobAction{
+	"test":     {MaxAttempts: 1, Priority: 10}, // interactif : passe devant les synchros
+	"odoo":     {MaxAttempts: 3, MaxParallel: 2},
+	"hiboutik": {MaxAttempts: 3, MaxParallel: 2},
+	"medias":   {MaxAttempts: 1, MaxParallel: 1, Priority: -10, Runner: JobRunnerFunc(runMediasJob)},
+}
+
+// getJobAction retourne la configuration d'une action : valeurs par défaut,
+// surchargées par le manifest jobs/<action>.json puis par les variables d'environnement
+func getJobAction(name string) JobAction {
+	action, ok := jobActions[name]
+	if !ok {
+		action = JobAction{MaxAttempts: 1}
+	}
+
+	if manifest, _ := loadJobManifest(name); manifest != nil {
+		action.Manifest = manifest
+		if manifest.Retry.MaxAttempts > 0 {
+			action.MaxAttempts = manifest.Retry.MaxAttempts
+		}
+		if timeout, err := time.ParseDuration(manifest.Timeout); err == nil {
+			action.Timeout = timeout
+		}
+		if inactivity, err := time.ParseDuration(manifest.Inactivity); err == nil {
+			action.Inactivity = inactivity
+		}
+		if delay, err := time.ParseDuration(manifest.Retry.BaseDelay); err == nil {
+			action.RetryDelay = delay
+		}
+		if cpu, err := time.ParseDuration(manifest.Limits.CPU); err == nil {
+			action.Limits.CPUTime = cpu
+		}
+		if manifest.Limits.Memory > 0 {
+			action.Limits.Memory = manifest.Limits.Memory
+		}
+		if manifest.Limits.OpenFiles > 0 {
+			action.Limits.OpenFiles = manifest.Limits.OpenFiles
+		}
+		action.Remote = manifest.Remote
+		action.Secrets = manifest.Secrets
+		action.Env = manifest.envNames()
+	}
+
+	prefix := "JOB_" + strings.ToUpper(name) + "_"
+	action.MaxAttempts = envInt(prefix+"MAX_ATTEMPTS", action.MaxAttempts)
+	action.MaxParallel = envInt(prefix+"MAX_PARALLEL", action.MaxParallel)
+	action.Priority = envInt(prefix+"PRIORITY", action.Priority)
+	action.Timeout = envDuration(prefix+"TIMEOUT", action.Timeout)
+	action.Inactivity = envDuration(prefix+"INACTIVITY", action.Inactivity)
+	action.Limits.CPUTime = envDuration(prefix+"CPU_LIMIT", action.Limits.CPUTime)
+	action.Limits.Memory = int64(envInt(prefix+"MEMORY_LIMIT", int(action.Limits.Memory)))
+	action.Limits.OpenFiles = envInt(prefix+"OPEN_FILES_LIMIT", action.Limits.OpenFiles)
+	action.Remote = envBool(prefix+"REMOTE", action.Remote)
+
+	if action.Timeout <= 0 {
+		action.Timeout = envDuration("JOB_TIMEOUT", defaultJobTimeout)
+	}
+	if action.Inactivity <= 0 {
+		action.Inactivity = envDuration("JOB_INACTIVITY", timeoutSecond)
+	}
+	if action.Limits.CPUTime <= 0 {
+		action.Limits.CPUTime = envDuration("JOB_CPU_LIMIT", defaultJobCPULimit)
+	}
+	if action.Limits.Memory <= 0 {
+		action.Limits.Memory = int64(envInt("JOB_MEMORY_LIMIT", defaultJobMemoryLimit))
+	}
+	if action.Limits.OpenFiles <= 0 {
+		action.Limits.OpenFiles = envInt("JOB_OPEN_FILES_LIMIT", defaultJobOpenFilesLimit)
+	}
+
+	return action
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+// readJobInput lit l'input JSON d'un job depuis un fichier ("-" pour l'entrée standard)
+func readJobInput(path string) (any, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	var raw []byte
+	var err error
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var input any
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, fmt.Errorf("invalid input JSON: %w", err)
+	}
+
+	return input, nil
+}
+
+// jobsRunCommand exécute une action au premier plan, avec le même pipeline que la file (startJob),
+// en affichant la progression et les logs dans le terminal
+func jobsRunCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var inputPath, group string
+	var noRecord bool
+
+	command := &cobra.Command{
+		Use:          "run <action>",
+		Example:      "jobs run odoo --input input.json --group GROUP_ID",
+		Short:        "Runs a job action in the foreground",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		Run: func(command *cobra.Command, args []string) {
+			// PocketBase ignore l'erreur de la commande : code de sortie explicite en cas d'échec
+			if err := runForegroundJob(app, args[0], inputPath, group, noRecord); err != nil {
+				fmt.Fprintln(os.Stderr, "❌", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	command.Flags().StringVar(&inputPath, "input", "", "JSON file with the job input (- for stdin)")
+	command.Flags().StringVar(&group, "group", "", "group of the job")
+	command.Flags().BoolVar(&noRecord, "no-record", false, "do not save the job, its logs and its files")
+
+	return command
+}
+
+// runForegroundJob crée et exécute le job de jobs run, erreur si le job ne se termine pas en "finished"
+func runForegroundJob(app *pocketbase.PocketBase, action string, inputPath string, group string, noRecord bool) error {
+	input, err := readJobInput(inputPath)
+	if err != nil {
+		return err
+	}
+
+	if group == "" && !noRecord {
+		return errors.New("missing --group (or --no-record)")
+	}
+	if group != "" {
+		if _, err := app.FindRecordById("groups", group); err != nil {
+			return fmt.Errorf("group %s not found", group)
+		}
+	}
+
+	collection, err := app.FindCollectionByNameOrId("jobs")
+	if err != nil {
+		return err
+	}
+
+	job := core.NewRecord(collection)
+	job.Id = core.GenerateDefaultRandomId()
+	job.Set("action", action)
+	job.Set("group", group)
+	job.Set("input", input)
+	job.Set("attempt", 1)
+
+	if err := validateJob(job); err != nil {
+		return err
+	}
+
+	// le job est enregistré en cours pour ne pas être pris par la file d'un serveur
+	if !noRecord {
+		job.Set("status", "processing")
+		if err := app.Save(job); err != nil {
+			return fmt.Errorf("failed to create job: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "▶️ job %s\n", job.Id)
+	}
+
+	// Ctrl-C : le job est annulé (process tués) avant l'arrêt de l'application
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer close(done)
+
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		cancel()
+		<-done
+		return e.Next()
+	})
+
+	startJob(ctx, app, job, JobRunOptions{
+		NoRecord: noRecord,
+		OnLog: func(level string, args ...any) {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				parts[i] = fmt.Sprint(arg)
+			}
+			fmt.Fprintf(os.Stderr, "%s %s\n", level, strings.Join(parts, "\t"))
+		},
+		OnUpdate: func(key string, val any) {
+			switch key {
+			case "progress":
+				fmt.Fprintf(os.Stderr, "⏳ %v%%\n", val)
+			case "status":
+				fmt.Fprintf(os.Stderr, "🔄 %v\n", val)
+			}
+		},
+	})
+
+	if result := job.Get("result"); result != nil {
+		raw, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(raw))
+	}
+
+	if status := job.GetString("status"); status != "finished" {
+		return fmt.Errorf("job %s: %s", status, job.GetString("error"))
+	}
+
+	return nil
+}
+
+// bindJobCommands ajoute la commande jobs à la CLI PocketBase
+func bindJobCommands(app *pocketbase.PocketBase) {
+	command := &cobra.Command{
+		Use:   "jobs",
+		Short: "Manage jobs",
+	}
+
+	command.AddCommand(jobsRunCommand(app))
+
+	app.RootCmd.AddCommand(command)
+}
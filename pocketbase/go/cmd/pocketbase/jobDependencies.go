@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// JobDependency est le résultat d'une dépendance, ajouté à l'input du job (input.dependencies)
+type JobDependency struct {
+	Id     string `json:"id"`
+	Action string `json:"action"`
+	Result any    `json:"result"`
+}
+
+// validateJobDependencies vérifie les dépendances (dependsOn) d'un job :
+// même groupe, pas d'auto-dépendance ni de cycle
+func validateJobDependencies(app core.App, job *core.Record) error {
+	ids := job.GetStringSlice("dependsOn")
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if slices.Contains(ids, job.Id) {
+		return fmt.Errorf("a job cannot depend on itself")
+	}
+
+	var input any
+	job.UnmarshalJSONField("input", &input)
+	if _, ok := input.(map[string]any); !ok && input != nil {
+		return fmt.Errorf("input must be an object to receive dependency results")
+	}
+
+	deps, err := app.FindRecordsByIds("jobs", ids)
+	if err != nil {
+		return err
+	}
+	if len(deps) != len(ids) {
+		return fmt.Errorf("dependency not found")
+	}
+
+	for _, dep := range deps {
+		if dep.GetString("group") != job.GetString("group") {
+			return fmt.Errorf("dependency %s belongs to another group", dep.Id)
+		}
+	}
+
+	// parcours des dépendances transitives : le job ne doit pas y apparaître
+	visited := map[string]bool{}
+	for len(deps) > 0 {
+		next := []string{}
+		for _, dep := range deps {
+			for _, id := range dep.GetStringSlice("dependsOn") {
+				if id == job.Id {
+					return fmt.Errorf("dependency cycle through job %s", dep.Id)
+				}
+				if !visited[id] {
+					visited[id] = true
+					next = append(next, id)
+				}
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		if deps, err = app.FindRecordsByIds("jobs", next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitDependencies indique si un job en attente doit encore attendre ses dépendances.
+// Si l'une d'elles est introuvable, a échoué (sans retry possible), a été annulée ou ignorée, le job passe en "skipped".
+func waitDependencies(app *pocketbase.PocketBase, job *core.Record) bool {
+	ids := job.GetStringSlice("dependsOn")
+	if len(ids) == 0 {
+		return false
+	}
+
+	deps, err := app.FindRecordsByIds("jobs", ids)
+	if err != nil {
+		app.Logger().Error("❌ failed to load job dependencies", "id", job.Id, "err", err)
+		return true
+	}
+
+	// une dépendance introuvable (supprimée de la base) ne terminera jamais
+	found := map[string]bool{}
+	for _, dep := range deps {
+		found[dep.Id] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			skipJob(app, job, fmt.Sprintf("Dependency %s missing", id))
+			return true
+		}
+	}
+
+	waiting := false
+
+	for _, dep := range deps {
+		switch dep.GetString("status") {
+		case "finished":
+		case "failed":
+			if canRetryJob(dep) {
+				waiting = true
+				continue
+			}
+			fallthrough
+		case "cancelled", "skipped", "deleted":
+			skipJob(app, job, fmt.Sprintf("Dependency %s %s", dep.Id, dep.GetString("status")))
+			return true
+		default:
+			waiting = true
+		}
+	}
+
+	if waiting {
+		app.Logger().Debug("⛓️ job waiting for dependencies", "id", job.Id)
+	}
+
+	return waiting
+}
+
+// skipJob abandonne un job dont une dépendance a échoué
+func skipJob(app *pocketbase.PocketBase, job *core.Record, reason string) {
+	app.Logger().Info("⏭️ job skipped", "id", job.Id, "reason", reason)
+
+	jobQueue.remove(job.Id)
+
+	job.Set("status", "skipped")
+	job.Set("error", reason)
+
+	if err := app.Save(job); err != nil {
+		app.Logger().Error("❌ failed to skip job", "id", job.Id, "err", err)
+	}
+}
+
+// resolveDependents réévalue les jobs en attente de job, une fois celui-ci terminé
+func resolveDependents(app *pocketbase.PocketBase, job *core.Record) {
+	if job.GetString("status") == "failed" && canRetryJob(job) {
+		return
+	}
+
+	dependents, err := app.FindRecordsByFilter(
+		"jobs",
+		"status = 'pending' && dependsOn:each ?= {:id}",
+		"created",
+		0,
+		0,
+		map[string]any{"id": job.Id},
+	)
+	if err != nil {
+		app.Logger().Error("❌ failed to load dependent jobs", "id", job.Id, "err", err)
+		return
+	}
+
+	for _, dependent := range dependents {
+		if !waitDependencies(app, dependent) {
+			jobQueue.push(dependent)
+		}
+	}
+}
+
+// setDependencyResults ajoute les résultats des dépendances à l'input du job (input.dependencies)
+func setDependencyResults(app *pocketbase.PocketBase, job *core.Record) error {
+	ids := job.GetStringSlice("dependsOn")
+	if len(ids) == 0 {
+		return nil
+	}
+
+	input := map[string]any{}
+	if raw := job.GetString("input"); raw != "" && raw != "null" {
+		if err := job.UnmarshalJSONField("input", &input); err != nil {
+			return fmt.Errorf("input must be an object to receive dependency results")
+		}
+	}
+
+	deps, err := app.FindRecordsByIds("jobs", ids)
+	if err != nil {
+		return err
+	}
+
+	results := make([]JobDependency, 0, len(deps))
+	for _, id := range ids {
+		for _, dep := range deps {
+			if dep.Id == id {
+				results = append(results, JobDependency{
+					Id:     dep.Id,
+					Action: dep.GetString("action"),
+					Result: dep.Get("result"),
+				})
+			}
+		}
+	}
+
+	input["dependencies"] = results
+	job.Set("input", input)
+
+	return nil
+}
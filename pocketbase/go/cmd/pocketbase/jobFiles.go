@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// prepareJobDir crée le dossier de travail privé d'un job et y copie ses fichiers d'entrée (champ files),
+// depuis le stockage PocketBase ou avec fetch s'il est fourni (worker distant)
+func prepareJobDir(app *pocketbase.PocketBase, job *core.Record, fetch func(name string, path string) error) (string, []string, error) {
+	dir, err := os.MkdirTemp("", "job-"+job.Id+"-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	names := job.GetStringSlice("files")
+	if len(names) == 0 {
+		return dir, nil, nil
+	}
+
+	inputDir := filepath.Join(dir, "input")
+	if err := os.MkdirAll(inputDir, 0o755); err != nil {
+		return dir, nil, err
+	}
+
+	if fetch == nil {
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return dir, nil, err
+		}
+		defer fsys.Close()
+
+		fetch = func(name string, path string) error {
+			return copyStoredFile(fsys, job.BaseFilesPath()+"/"+name, path)
+		}
+	}
+
+	inputFiles := make([]string, 0, len(names))
+
+	for _, name := range names {
+		path := filepath.Join(inputDir, filepath.Base(name))
+		if err := fetch(name, path); err != nil {
+			return dir, nil, fmt.Errorf("input file %s: %w", name, err)
+		}
+		inputFiles = append(inputFiles, path)
+	}
+
+	return dir, inputFiles, nil
+}
+
+// copyStoredFile copie un fichier du stockage PocketBase vers un chemin local
+func copyStoredFile(fsys *filesystem.System, key string, path string) error {
+	reader, err := fsys.GetReader(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, reader)
+	return err
+}
+
+// outputFile résout un fichier produit par le job, qui doit se trouver dans son dossier de travail
+func (jc *JobContext) outputFile(path string) (*filesystem.File, error) {
+	if jc.Dir == "" {
+		return nil, fmt.Errorf("no job directory")
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(jc.Dir, path)
+	}
+
+	// résout les liens symboliques pour ne pas sortir du dossier
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(jc.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("file outside of job directory")
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file")
+	}
+
+	return filesystem.NewFileFromPath(resolved)
+}
+
+// AddMedia crée un media dans le groupe du job à partir d'un fichier produit dans Dir
+func (jc *JobContext) AddMedia(path string) (*core.Record, error) {
+	if jc.Group == "" {
+		return nil, fmt.Errorf("no group")
+	}
+
+	file, err := jc.outputFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jc.media(file)
+}
+
+// createMedia crée un media dans un groupe à partir d'un fichier
+func createMedia(app core.App, group string, file *filesystem.File) (*core.Record, error) {
+	collection, err := app.FindCollectionByNameOrId("medias")
+	if err != nil {
+		return nil, err
+	}
+
+	media := core.NewRecord(collection)
+	media.Set("name", file.OriginalName)
+	media.Set("group", group)
+	media.Set("file", file)
+
+	updateMediaInfo(app.Logger(), media, file)
+
+	if err := app.Save(media); err != nil {
+		return nil, err
+	}
+
+	return media, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const defaultIdempotencyWindow = 10 * time.Minute // Durée pendant laquelle un job terminé est réutilisé
+
+// jobCreateMu sérialise la recherche d'un job existant et la création du nouveau côté serveur
+// (POST /api/jobs/run, automations). Les créations par l'API des records sont protégées par
+// l'index unique (group, idempotencyKey) des jobs en attente ou en cours.
+var jobCreateMu sync.Mutex
+
+// findIdempotentJob retourne le job du groupe ayant la même clé d'idempotence :
+// en attente ou en cours, ou terminé depuis moins de JOB_IDEMPOTENCY_WINDOW. nil si aucun.
+func findIdempotentJob(app core.App, group string, key string) *core.Record {
+	return findJobByKey(app, group, key, envDuration("JOB_IDEMPOTENCY_WINDOW", defaultIdempotencyWindow))
+}
+
+// findJobByKey retourne le job du groupe ayant la clé d'idempotence key : en attente ou en cours,
+// ou terminé depuis moins de window (0 = seulement en attente ou en cours). nil si aucun.
+func findJobByKey(app core.App, group string, key string, window time.Duration) *core.Record {
+	if key == "" {
+		return nil
+	}
+
+	filter := "group = {:group} && idempotencyKey = {:key} && (status = '' || status = 'pending' || status = 'processing'"
+	params := map[string]any{"group": group, "key": key}
+	if window > 0 {
+		filter += " || (status = 'finished' && updated >= {:since})"
+		params["since"] = time.Now().Add(-window).UTC().Format(types.DefaultDateLayout)
+	}
+
+	job, err := app.FindFirstRecordByFilter("jobs", filter+")", params)
+	if err != nil {
+		return nil
+	}
+
+	return job
+}
+
+// dedupeJobRequest retourne le job existant au lieu d'en créer un doublon (hook de requête create).
+// Une création simultanée avec la même clé est rejetée par l'index unique : le job créé est alors retourné.
+func dedupeJobRequest(e *core.RecordRequestEvent) error {
+	key := e.Record.GetString("idempotencyKey")
+	if key == "" {
+		return e.Next()
+	}
+
+	group := e.Record.GetString("group")
+
+	existing := findIdempotentJob(e.App, group, key)
+	if existing == nil {
+		err := e.Next()
+		if err == nil {
+			return nil
+		}
+		if existing = findIdempotentJob(e.App, group, key); existing == nil {
+			return err
+		}
+	}
+
+	e.App.Logger().Info("♻️ duplicate job request", "id", existing.Id, "idempotencyKey", key)
+
+	// même réponse qu'une création : expand et hooks OnRecordEnrich
+	if err := apis.EnrichRecord(e.RequestEvent, existing); err != nil {
+		return e.JSON(500, errorJSON("Failed to enrich job: %s", err.Error()))
+	}
+
+	return e.JSON(200, existing)
+}
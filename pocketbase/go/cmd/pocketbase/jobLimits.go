@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// jobLimitsCommand est l'argument qui fait du binaire du serveur le lanceur d'un process de job
+// avec ses limites de ressources (voir wrapJobLimits)
+const jobLimitsCommand = "__job-limits"
+
+const (
+	defaultJobCPULimit       = 10 * time.Minute // Temps CPU maximal par défaut d'un job
+	defaultJobMemoryLimit    = 1024             // Mémoire maximale par défaut d'un job (Mo)
+	defaultJobOpenFilesLimit = 256              // Fichiers ouverts maximum par défaut d'un job
+)
+
+// JobLimits sont les limites de ressources appliquées aux process d'un job
+type JobLimits struct {
+	CPUTime   time.Duration // temps CPU (SIGXCPU puis SIGKILL au dépassement)
+	Memory    int64         // mémoire allouée (Mo), et taille du tas V8 pour Deno
+	OpenFiles int           // descripteurs de fichiers ouverts
+}
+
+// JobUsage est la consommation d'un process de job, enregistrée sur le job
+type JobUsage struct {
+	PeakMemory int64         // mémoire résidente maximale (octets)
+	CPUTime    time.Duration // temps CPU utilisateur + système
+}
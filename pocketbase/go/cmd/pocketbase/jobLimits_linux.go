@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// wrapJobLimits fait démarrer la commande par le binaire du serveur (jobLimitsCommand), qui applique
+// les limites à son propre process puis exécute la commande : elles sont en place dès l'exec
+// et héritées par ses sous-process.
+func wrapJobLimits(cmd *exec.Cmd, limits JobLimits) error {
+	if cmd.Err != nil {
+		return nil // commande introuvable : l'erreur est retournée par Start
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cpu := int64((limits.CPUTime + time.Second - 1) / time.Second)
+	args := []string{
+		self,
+		jobLimitsCommand,
+		strconv.FormatInt(cpu, 10),
+		strconv.FormatInt(limits.Memory, 10),
+		strconv.Itoa(limits.OpenFiles),
+		cmd.Path,
+	}
+
+	cmd.Path = self
+	cmd.Args = append(args, cmd.Args...)
+
+	return nil
+}
+
+// execWithJobLimits est le point d'entrée de jobLimitsCommand : args = <cpu (s)> <mémoire (Mo)> <fichiers> <path> <argv...>.
+// La mémoire est limitée par RLIMIT_DATA : RLIMIT_AS est inutilisable avec V8,
+// qui réserve de très grandes plages d'adresses virtuelles.
+func execWithJobLimits(args []string) error {
+	if len(args) < 5 {
+		return fmt.Errorf("usage: %s <cpu> <memory> <files> <path> <args...>", jobLimitsCommand)
+	}
+
+	values := make([]uint64, 3)
+	for i := range values {
+		value, err := strconv.ParseUint(args[i], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid limit %q", args[i])
+		}
+		values[i] = value
+	}
+	cpu, memory, files := values[0], values[1], values[2]
+
+	set := func(resource int, value uint64, max uint64) error {
+		return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: max})
+	}
+
+	if cpu > 0 {
+		// SIGXCPU à la limite, SIGKILL une seconde plus tard
+		if err := set(unix.RLIMIT_CPU, cpu, cpu+1); err != nil {
+			return err
+		}
+	}
+
+	if memory > 0 {
+		bytes := memory * 1024 * 1024
+		if err := set(unix.RLIMIT_DATA, bytes, bytes); err != nil {
+			return err
+		}
+	}
+
+	if files > 0 {
+		if err := set(unix.RLIMIT_NOFILE, files, files); err != nil {
+			return err
+		}
+	}
+
+	// le process garde son pid et son groupe : l'arrêt et la consommation relevée restent ceux du job
+	return syscall.Exec(args[3], args[4:], os.Environ())
+}
+
+// jobProcessUsage retourne la consommation du process terminé (sous-process attendus inclus)
+func jobProcessUsage(cmd *exec.Cmd) (JobUsage, bool) {
+	if cmd.ProcessState == nil {
+		return JobUsage{}, false
+	}
+
+	rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return JobUsage{}, false
+	}
+
+	return JobUsage{
+		PeakMemory: rusage.Maxrss * 1024, // ko sous Linux
+		CPUTime:    time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano()),
+	}, true
+}
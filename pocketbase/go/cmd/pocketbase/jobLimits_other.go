@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// wrapJobLimits : les limites de ressources par process ne sont appliquées que sous Linux
+func wrapJobLimits(cmd *exec.Cmd, limits JobLimits) error {
+	return nil
+}
+
+// execWithJobLimits : jobLimitsCommand n'est utilisée que sous Linux
+func execWithJobLimits(args []string) error {
+	return fmt.Errorf("%s is only supported on linux", jobLimitsCommand)
+}
+
+// jobProcessUsage : la consommation des process n'est relevée que sous Linux
+func jobProcessUsage(cmd *exec.Cmd) (JobUsage, bool) {
+	return JobUsage{}, false
+}
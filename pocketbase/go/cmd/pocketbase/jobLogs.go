@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	maxJobLogLines      = 5000  // Lignes de log conservées par tentative d'un job (au-delà elles sont ignorées)
+	maxJobLogMessageLen = 20000 // Taille maximale d'un message (champ message de job_logs)
+)
+
+// jobLogLine est une ligne de log en attente d'enregistrement
+type jobLogLine struct {
+	level   string
+	message string
+	time    time.Time
+}
+
+// jobLogWriter bufferise les logs d'un job et les enregistre ligne par ligne
+// dans la collection job_logs à chaque flush (suivi en realtime par l'UI)
+type jobLogWriter struct {
+	app     *pocketbase.PocketBase
+	job     *core.Record
+	mu      sync.Mutex
+	pending []jobLogLine
+	seq     int // numéro de la prochaine ligne (toutes tentatives confondues)
+	count   int // lignes déjà enregistrées pour la tentative en cours
+	max     int // lignes par tentative
+}
+
+// newJobLogWriter reprend la numérotation après les lignes déjà enregistrées (tentatives précédentes)
+// et le décompte des lignes de la tentative en cours
+func newJobLogWriter(app *pocketbase.PocketBase, job *core.Record) *jobLogWriter {
+	w := &jobLogWriter{
+		app: app,
+		job: job,
+		max: envInt("JOB_MAX_LOG_LINES", maxJobLogLines),
+	}
+
+	last, err := app.FindRecordsByFilter("job_logs", "job = {:job}", "-seq", 1, 0, map[string]any{"job": job.Id})
+	if err == nil && len(last) > 0 {
+		w.seq = last[0].GetInt("seq") + 1
+	}
+
+	count, err := app.CountRecords("job_logs", dbx.HashExp{"job": job.Id, "attempt": max(job.GetInt("attempt"), 1)})
+	if err == nil {
+		w.count = int(count)
+	}
+
+	return w
+}
+
+// add ajoute une ligne (level : E, W, I ou D), les arguments sont séparés par une tabulation
+func (w *jobLogWriter) add(level string, args ...any) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = fmt.Sprint(arg)
+	}
+
+	message := strings.Join(parts, "\t")
+	if runes := []rune(message); len(runes) > maxJobLogMessageLen {
+		message = string(runes[:maxJobLogMessageLen])
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.count+len(w.pending) >= w.max {
+		return
+	}
+
+	// la dernière ligne disponible signale la troncature
+	if w.count+len(w.pending) == w.max-1 {
+		level, message = "W", fmt.Sprintf("log limit reached (%d lines), next lines are dropped", w.max)
+	}
+
+	w.pending = append(w.pending, jobLogLine{level: level, message: message, time: time.Now()})
+}
+
+// flush enregistre les lignes en attente, dans une transaction
+func (w *jobLogWriter) flush() error {
+	w.mu.Lock()
+	lines := w.pending
+	w.pending = nil
+	seq := w.seq
+	w.seq += len(lines)
+	w.count += len(lines)
+	w.mu.Unlock()
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	collection, err := w.app.FindCachedCollectionByNameOrId("job_logs")
+	if err != nil {
+		return err
+	}
+
+	return w.app.RunInTransaction(func(txApp core.App) error {
+		for i, line := range lines {
+			record := core.NewRecord(collection)
+			record.Set("job", w.job.Id)
+			record.Set("group", w.job.GetString("group"))
+			record.Set("attempt", max(w.job.GetInt("attempt"), 1))
+			record.Set("seq", seq+i)
+			record.Set("level", line.level)
+			record.Set("message", line.message)
+			record.Set("time", line.time)
+
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteJobLogs supprime les logs d'un job (relance complète)
+func deleteJobLogs(app *pocketbase.PocketBase, job *core.Record) error {
+	records, err := app.FindRecordsByFilter("job_logs", "job = {:job}", "", 0, 0, map[string]any{"job": job.Id})
+	if err != nil {
+		return err
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		for _, record := range records {
+			if err := txApp.Delete(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
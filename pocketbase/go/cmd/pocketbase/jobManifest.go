@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// JobManifest est la déclaration d'une action, lue depuis jobs/<action>.json
+//
+//	{
+//	  "input": { ...JSON Schema... },
+//	  "permissions": { "net": ["api.example.com"], "env": ["ADMIN_EMAIL"], "read": ["/app/common"] },
+//	  "timeout": "30m",
+//	  "inactivity": "1m",
+//	  "limits": { "cpu": "5m", "memory": 512, "openFiles": 256 },
+//	  "retry": { "maxAttempts": 3, "baseDelay": "30s" },
+//	  "secrets": ["ODOO_PASSWORD"],
+//	  "remote": false
+//	}
+type JobManifest struct {
+	Input       map[string]any             `json:"input,omitempty"`
+	Permissions map[string]json.RawMessage `json:"permissions,omitempty"`
+	Timeout     string                     `json:"timeout,omitempty"`    // durée maximale d'une exécution
+	Inactivity  string                     `json:"inactivity,omitempty"` // durée maximale sans progress ni log
+	Limits      struct {
+		CPU       string `json:"cpu,omitempty"`       // temps CPU maximal
+		Memory    int64  `json:"memory,omitempty"`    // mémoire maximale en Mo
+		OpenFiles int    `json:"openFiles,omitempty"` // nombre maximal de fichiers ouverts
+	} `json:"limits"`
+	Retry struct {
+		MaxAttempts int    `json:"maxAttempts,omitempty"`
+		BaseDelay   string `json:"baseDelay,omitempty"`
+	} `json:"retry"`
+	Secrets []string `json:"secrets,omitempty"` // secrets du groupe injectés en variables d'environnement
+	Remote  bool     `json:"remote,omitempty"`  // exécutée uniquement par les workers distants
+}
+
+// Permissions Deno supportées, dans l'ordre des flags générés
+var denoPermissions = []string{"net", "env", "read", "write", "run", "sys"}
+
+type cachedManifest struct {
+	modTime  time.Time
+	manifest *JobManifest
+	err      error
+}
+
+var manifestCache sync.Map // action => *cachedManifest
+
+// loadJobManifest lit (avec cache invalidé sur modification) le manifest d'une action.
+// Retourne nil sans erreur si l'action n'a pas de manifest.
+func loadJobManifest(action string) (*JobManifest, error) {
+	path := filepath.Join("jobs", filepath.Base(action)+".json")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil
+	}
+
+	if cached, ok := manifestCache.Load(action); ok {
+		if c := cached.(*cachedManifest); c.modTime.Equal(info.ModTime()) {
+			return c.manifest, c.err
+		}
+	}
+
+	manifest := &JobManifest{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, manifest)
+	}
+	if err == nil {
+		_, err = manifest.denoFlags(nil)
+	}
+	if err != nil {
+		manifest = nil
+		err = fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+
+	manifestCache.Store(action, &cachedManifest{
+		modTime:  info.ModTime(),
+		manifest: manifest,
+		err:      err,
+	})
+
+	return manifest, err
+}
+
+// denoFlags traduit les permissions du manifest en flags Deno.
+// Une permission vaut true (accès complet) ou une liste (ex: hôtes réseau, variables d'environnement).
+// extra complète les listes (ex: dossier de travail du job), sans restreindre une permission complète.
+func (m *JobManifest) denoFlags(extra map[string][]string) ([]string, error) {
+	flags := []string{}
+
+	for name := range m.Permissions {
+		if !containsString(denoPermissions, name) {
+			return nil, fmt.Errorf("unknown permission: %s", name)
+		}
+	}
+	for _, name := range m.Secrets {
+		if !secretNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid secret name: %s", name)
+		}
+	}
+
+	for _, name := range denoPermissions {
+		values := extra[name]
+
+		if raw, ok := m.Permissions[name]; ok {
+			var all bool
+			if err := json.Unmarshal(raw, &all); err == nil {
+				if all {
+					flags = append(flags, "--allow-"+name)
+					continue
+				}
+			} else {
+				var declared []string
+				if err := json.Unmarshal(raw, &declared); err != nil {
+					return nil, fmt.Errorf("invalid %s permission: expected true or a list of strings", name)
+				}
+				values = append(declared, values...)
+			}
+		}
+
+		if len(values) > 0 {
+			flags = append(flags, "--allow-"+name+"="+strings.Join(values, ","))
+		}
+	}
+
+	return flags, nil
+}
+
+// envNames retourne les variables listées par la permission env
+// (une permission complète, true, ne transmet aucune variable du serveur en plus de la base)
+func (m *JobManifest) envNames() []string {
+	var names []string
+	json.Unmarshal(m.Permissions["env"], &names)
+	return names
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateJob vérifie que l'input d'un job est conforme au schéma du manifest de son action
+func validateJob(job *core.Record) error {
+	action := job.GetString("action")
+
+	manifest, err := loadJobManifest(action)
+	if err != nil {
+		return err
+	}
+
+	if manifest == nil || manifest.Input == nil {
+		return nil
+	}
+
+	var input any
+	if err := job.UnmarshalJSONField("input", &input); err != nil {
+		return fmt.Errorf("invalid input: %w", err)
+	}
+
+	if err := validateJSONSchema(manifest.Input, input, "input"); err != nil {
+		return fmt.Errorf("invalid input for action %s: %w", action, err)
+	}
+
+	return nil
+}
+
+// checkJobRequest rejette à la création (ou à la relance) les jobs invalides,
+// et toute création ou relance pendant l'arrêt du serveur
+func checkJobRequest(e *core.RecordRequestEvent) error {
+	status := e.Record.GetString("status")
+	if status != "" && status != "pending" {
+		return e.Next()
+	}
+
+	if stopping.Load() && (e.Record.IsNew() || e.Record.Original().GetString("status") != status) {
+		return e.JSON(503, errorJSON("Server is shutting down"))
+	}
+
+	if err := validateJob(e.Record); err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	if err := validateJobDependencies(e.App, e.Record); err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	return e.Next()
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+	"time"
+)
+
+// setupJobProcess : sans groupes de process, seul le process principal est tué à l'arrêt du job
+func setupJobProcess(cmd *exec.Cmd, grace time.Duration) {
+	cmd.WaitDelay = grace
+}
+
+// killJobProcessGroup est sans effet sans groupes de process
+func killJobProcessGroup(cmd *exec.Cmd) {}
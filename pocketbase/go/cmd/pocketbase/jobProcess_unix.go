@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setupJobProcess lance le process dans son propre groupe : à l'arrêt du job
+// (annulation, timeout, inactivité) tout le groupe reçoit SIGTERM, puis SIGKILL après grace.
+func setupJobProcess(cmd *exec.Cmd, grace time.Duration) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+
+		time.AfterFunc(grace, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+
+	// filet de sécurité si des sous-process gardent stdout/stderr ouverts
+	cmd.WaitDelay = 2 * grace
+}
+
+// killJobProcessGroup tue les sous-process restants une fois le process principal terminé
+func killJobProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Politiques appliquées au démarrage aux jobs restés en "processing" (serveur arrêté en cours d'exécution)
+const (
+	orphanPolicyRequeue = "requeue" // le job repasse en "pending" et sera relancé
+	orphanPolicyFail    = "fail"    // le job est marqué en échec
+)
+
+// queuedJob est l'entrée en mémoire d'un job en attente
+type queuedJob struct {
+	id          string
+	group       string
+	action      string
+	priority    int // JobAction.Priority : ordonne les jobs de tous les groupes
+	rank        int // priorité du record, fixée par le client : n'ordonne que les jobs de son groupe
+	created     types.DateTime
+	runAfter    time.Time // pas de démarrage avant cette date (retry différé)
+	remote      bool      // JobAction.Remote, résolu à l'entrée dans la file
+	maxParallel int       // JobAction.MaxParallel, résolu à l'entrée dans la file
+}
+
+// runningJob est un job en cours d'exécution, annulable
+type runningJob struct {
+	group  string
+	action string
+	remote bool            // exécuté par un worker distant (bail)
+	ctx    context.Context // annulé par cancel (un worker l'apprend à son heartbeat)
+	cancel context.CancelFunc
+}
+
+// JobQueue est l'ordonnanceur des jobs.
+// La source de vérité reste la collection jobs (status = "pending") :
+// la file en mémoire n'est qu'un index reconstruit au démarrage par recoverJobs.
+//
+// Le job de plus haute priorité d'action démarre en premier ; à priorité égale, les groupes
+// sont servis à tour de rôle (le groupe servi le moins récemment passe en premier), et la priorité
+// du record (choisie par le client) ne départage que les jobs d'un même groupe pendant son tour.
+// Au plus maxParallel jobs tournent localement, maxPerGroup par groupe et
+// JobAction.MaxParallel par action (ces deux quotas comptent aussi les jobs des workers distants).
+type JobQueue struct {
+	mu          sync.Mutex
+	pending     map[string]*queuedJob
+	running     map[string]*runningJob
+	served      map[string]uint64 // tour de service le plus récent de chaque groupe
+	turn        uint64
+	maxParallel int
+	maxPerGroup int
+	wake        chan struct{} // signale l'arrivée d'un job ou la libération d'un slot
+}
+
+var jobQueue = newJobQueue(
+	envInt("JOB_MAX_PARALLEL", maxParallelJobs),
+	envInt("JOB_MAX_PARALLEL_PER_GROUP", maxParallelJobsPerGroup),
+)
+
+func newJobQueue(maxParallel int, maxPerGroup int) *JobQueue {
+	return &JobQueue{
+		pending:     map[string]*queuedJob{},
+		running:     map[string]*runningJob{},
+		served:      map[string]uint64{},
+		maxParallel: maxParallel,
+		maxPerGroup: maxPerGroup,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// signal réveille l'ordonnanceur
+func (q *JobQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push ajoute un job en attente (sans doublon)
+func (q *JobQueue) push(job *core.Record) {
+	// l'action (manifest sur disque) est résolue hors du verrou, une seule fois
+	action := getJobAction(job.GetString("action"))
+
+	q.mu.Lock()
+	q.pending[job.Id] = &queuedJob{
+		id:          job.Id,
+		group:       job.GetString("group"),
+		action:      job.GetString("action"),
+		priority:    action.Priority,
+		rank:        job.GetInt("priority"),
+		created:     job.GetDateTime("created"),
+		runAfter:    job.GetDateTime("runAfter").Time(),
+		remote:      action.Remote,
+		maxParallel: action.MaxParallel,
+	}
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// adopt enregistre comme en cours un job qu'un worker distant exécutait avant le redémarrage
+// (il compte dans les quotas et son annulation est transmise au prochain heartbeat)
+func (q *JobQueue) adopt(job *core.Record) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.running[job.Id] = &runningJob{
+		group:  job.GetString("group"),
+		action: job.GetString("action"),
+		remote: true,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// remove retire un job de la file avant son démarrage
+func (q *JobQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.pending[id]
+	delete(q.pending, id)
+
+	return ok
+}
+
+// isCancelled indique si l'annulation d'un job en cours a été demandée
+func (q *JobQueue) isCancelled(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.running[id]
+	return ok && job.ctx.Err() != nil
+}
+
+// cancel interrompt un job en cours d'exécution
+func (q *JobQueue) cancel(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.running[id]
+	if ok {
+		job.cancel()
+	}
+
+	return ok
+}
+
+// done libère le slot d'un job terminé
+func (q *JobQueue) done(id string) {
+	q.mu.Lock()
+	if job, ok := q.running[id]; ok {
+		job.cancel()
+		delete(q.running, id)
+	}
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// canStart vérifie les quotas de concurrence (total local, groupe, action).
+// Un worker distant (remote) n'occupe pas de slot local ; une action Remote ne démarre pas localement.
+func (q *JobQueue) canStart(item *queuedJob, remote bool) bool {
+	if !remote && item.remote {
+		return false
+	}
+
+	maxPerAction := item.maxParallel
+	localCount, groupCount, actionCount := 0, 0, 0
+
+	for _, job := range q.running {
+		if !job.remote {
+			localCount++
+		}
+		if job.group == item.group {
+			groupCount++
+		}
+		if job.action == item.action {
+			actionCount++
+		}
+	}
+
+	if !remote && localCount >= q.maxParallel {
+		return false
+	}
+	if q.maxPerGroup > 0 && groupCount >= q.maxPerGroup {
+		return false
+	}
+
+	return maxPerAction <= 0 || actionCount < maxPerAction
+}
+
+// before indique si a doit passer avant b : priorité d'action la plus haute,
+// puis groupe servi le moins récemment, puis priorité du record au sein d'un groupe, puis job le plus ancien
+func (q *JobQueue) before(a *queuedJob, b *queuedJob) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if q.served[a.group] != q.served[b.group] {
+		return q.served[a.group] < q.served[b.group]
+	}
+	if a.group == b.group && a.rank != b.rank {
+		return a.rank > b.rank
+	}
+	return a.created.Before(b.created)
+}
+
+// next retire de la file le prochain job à démarrer localement et lui réserve un slot.
+// Sinon retourne le délai avant le prochain job différé (-1 si aucun).
+func (q *JobQueue) next() (*queuedJob, context.Context, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	best, wait := q.best(nil)
+	if best == nil {
+		return nil, nil, wait
+	}
+
+	return best, q.start(best, false), wait
+}
+
+// lease retire de la file le prochain job d'une des actions d'un worker distant (nil si aucun)
+func (q *JobQueue) lease(actions []string) *queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	best, _ := q.best(actions)
+	if best == nil {
+		return nil
+	}
+
+	q.start(best, true)
+
+	return best
+}
+
+// best choisit le prochain job démarrable (parmi actions pour un worker distant, si non nil)
+// et retourne le délai avant le prochain job différé (-1 si aucun). Aucun pendant l'arrêt du serveur.
+func (q *JobQueue) best(actions []string) (*queuedJob, time.Duration) {
+	if stopping.Load() {
+		return nil, -1
+	}
+
+	now := time.Now()
+	wait := time.Duration(-1)
+	remote := actions != nil
+
+	var best *queuedJob
+	for _, item := range q.pending {
+		if delay := item.runAfter.Sub(now); delay > 0 {
+			if wait < 0 || delay < wait {
+				wait = delay
+			}
+			continue
+		}
+		if remote && !containsString(actions, item.action) {
+			continue
+		}
+		if !q.canStart(item, remote) {
+			continue
+		}
+		if best == nil || q.before(item, best) {
+			best = item
+		}
+	}
+
+	return best, wait
+}
+
+// start passe un job de la file à l'état en cours et retourne son contexte d'exécution
+// (un job local est aussi interrompu à la fin du délai de grâce de l'arrêt du serveur)
+func (q *JobQueue) start(item *queuedJob, remote bool) context.Context {
+	parent := tasksCtx
+	if remote {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	delete(q.pending, item.id)
+	q.running[item.id] = &runningJob{
+		group:  item.group,
+		action: item.action,
+		remote: remote,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	q.turn++
+	q.served[item.group] = q.turn
+
+	return ctx
+}
+
+// run démarre les jobs dès qu'ils sont prêts et qu'un slot est disponible
+func (q *JobQueue) run(app *pocketbase.PocketBase) {
+	for {
+		item, ctx, wait := q.next()
+		if item != nil {
+			runningTasks.Add(1)
+			safeGo(func() {
+				defer runningTasks.Done()
+				defer q.done(item.id)
+				runQueuedJob(ctx, app, item.id)
+			})
+			continue
+		}
+
+		if wait < 0 {
+			<-q.wake
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// runQueuedJob recharge le job depuis la base et l'exécute s'il est toujours en attente
+func runQueuedJob(ctx context.Context, app *pocketbase.PocketBase, id string) {
+	job, err := app.FindRecordById("jobs", id)
+	if err != nil {
+		app.Logger().Warn("⚠️ queued job not found", "id", id, "err", err)
+		return
+	}
+
+	if job.GetString("status") != "pending" {
+		app.Logger().Debug("queued job skipped", "id", id, "status", job.GetString("status"))
+		return
+	}
+
+	app.Logger().Debug("🚀 slot ok", "id", id)
+	startJob(ctx, app, job, JobRunOptions{})
+
+	if job.GetString("status") == "failed" {
+		retryJob(app, job)
+	}
+}
+
+// recoverJobs reconstruit la file au démarrage :
+// les jobs orphelins (processing local) sont relancés ou marqués en échec selon JOB_ORPHAN_POLICY,
+// les jobs confiés à un worker distant restent à lui (l'expiration du bail les reprend s'il a disparu),
+// puis les jobs pending dont les dépendances sont terminées sont remis en file par ordre de création.
+func recoverJobs(app *pocketbase.PocketBase) {
+	logger := app.Logger()
+	policy := envString("JOB_ORPHAN_POLICY", orphanPolicyRequeue)
+
+	leased, err := app.FindRecordsByFilter("jobs", "status = 'processing' && worker != ''", "created", 0, 0)
+	if err != nil {
+		logger.Error("❌ failed to load leased jobs", "err", err)
+	}
+
+	for _, job := range leased {
+		jobQueue.adopt(job)
+	}
+
+	orphans, err := app.FindRecordsByFilter("jobs", "status = 'processing' && worker = ''", "created", 0, 0)
+	if err != nil {
+		logger.Error("❌ failed to load orphan jobs", "err", err)
+	}
+
+	for _, job := range orphans {
+		if policy == orphanPolicyFail {
+			job.Set("status", "failed")
+			job.Set("error", "Interrupted by server restart")
+		} else {
+			job.Set("status", "pending")
+			job.Set("progress", 0)
+			job.Set("error", "")
+			job.Set("errorCode", "")
+		}
+		// dossier de travail laissé par un arrêt brutal
+		removeTempFiles("job-" + job.Id + "-*")
+
+		if err := app.Save(job); err != nil {
+			logger.Error("❌ failed to recover orphan job", "id", job.Id, "err", err)
+			continue
+		}
+
+		logger.Info("♻️ orphan job recovered", "id", job.Id, "policy", policy)
+	}
+
+	pending, err := app.FindRecordsByFilter("jobs", "status = 'pending'", "created", 0, 0)
+	if err != nil {
+		logger.Error("❌ failed to load pending jobs", "err", err)
+		return
+	}
+
+	for _, job := range pending {
+		if !waitDependencies(app, job) {
+			jobQueue.push(job)
+		}
+	}
+
+	logger.Info("📋 jobs queue recovered", "pending", len(pending), "orphans", len(orphans), "leased", len(leased))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// testDateTime retourne une date de création décalée de offset secondes
+func testDateTime(t *testing.T, offset int) types.DateTime {
+	t.Helper()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dt, err := types.ParseDateTime(base.Add(time.Duration(offset) * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dt
+}
+
+func TestJobQueueBefore(t *testing.T) {
+	q := newJobQueue(3, 0)
+	q.served = map[string]uint64{"g1": 2, "g2": 1}
+
+	scenarios := []struct {
+		name     string
+		a        *queuedJob
+		b        *queuedJob
+		expected bool
+	}{
+		{
+			"higher action priority first",
+			&queuedJob{group: "g1", priority: 10, created: testDateTime(t, 2)},
+			&queuedJob{group: "g2", priority: 0, created: testDateTime(t, 1)},
+			true,
+		},
+		{
+			"lower action priority last",
+			&queuedJob{group: "g2", priority: -10, created: testDateTime(t, 1)},
+			&queuedJob{group: "g1", priority: 0, created: testDateTime(t, 2)},
+			false,
+		},
+		{
+			"least recently served group first",
+			&queuedJob{group: "g2", created: testDateTime(t, 2)},
+			&queuedJob{group: "g1", created: testDateTime(t, 1)},
+			true,
+		},
+		{
+			"never served group first",
+			&queuedJob{group: "g3", created: testDateTime(t, 2)},
+			&queuedJob{group: "g2", created: testDateTime(t, 1)},
+			true,
+		},
+		{
+			"record priority ignored across groups",
+			&queuedJob{group: "g1", rank: 100, created: testDateTime(t, 1)},
+			&queuedJob{group: "g2", created: testDateTime(t, 2)},
+			false,
+		},
+		{
+			"record priority within a group",
+			&queuedJob{group: "g1", rank: 5, created: testDateTime(t, 2)},
+			&queuedJob{group: "g1", rank: 1, created: testDateTime(t, 1)},
+			true,
+		},
+		{
+			"oldest job first",
+			&queuedJob{group: "g1", created: testDateTime(t, 1)},
+			&queuedJob{group: "g1", created: testDateTime(t, 2)},
+			true,
+		},
+		{
+			"newest job last",
+			&queuedJob{group: "g1", created: testDateTime(t, 2)},
+			&queuedJob{group: "g1", created: testDateTime(t, 1)},
+			false,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if result := q.before(s.a, s.b); result != s.expected {
+				t.Fatalf("Expected %v, got %v", s.expected, result)
+			}
+		})
+	}
+}
+
+func TestJobQueueBest(t *testing.T) {
+	running := func(group string, action string, remote bool) *runningJob {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &runningJob{group: group, action: action, remote: remote, ctx: ctx, cancel: cancel}
+	}
+
+	scenarios := []struct {
+		name        string
+		maxParallel int
+		maxPerGroup int
+		pending     []*queuedJob
+		running     []*runningJob
+		served      map[string]uint64
+		actions     []string // worker distant si non nil
+		expected    string   // id attendu, "" si aucun
+		wait        bool     // délai avant un job différé attendu
+	}{
+		{
+			name:        "empty queue",
+			maxParallel: 3,
+			expected:    "",
+		},
+		{
+			name:        "round-robin between groups",
+			maxParallel: 3,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", created: testDateTime(t, 1)},
+				{id: "a2", group: "a", created: testDateTime(t, 2)},
+				{id: "b1", group: "b", created: testDateTime(t, 3)},
+			},
+			served:   map[string]uint64{"a": 1},
+			expected: "b1",
+		},
+		{
+			name:        "action priority before round-robin",
+			maxParallel: 3,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", priority: 10, created: testDateTime(t, 2)},
+				{id: "b1", group: "b", created: testDateTime(t, 1)},
+			},
+			served:   map[string]uint64{"a": 1},
+			expected: "a1",
+		},
+		{
+			name:        "deferred job not started",
+			maxParallel: 3,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", created: testDateTime(t, 1), runAfter: time.Now().Add(time.Hour)},
+			},
+			expected: "",
+			wait:     true,
+		},
+		{
+			name:        "local slots full",
+			maxParallel: 1,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", created: testDateTime(t, 1)},
+			},
+			running:  []*runningJob{running("b", "test", false)},
+			expected: "",
+		},
+		{
+			name:        "remote jobs do not use local slots",
+			maxParallel: 1,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", created: testDateTime(t, 1)},
+			},
+			running:  []*runningJob{running("b", "test", true)},
+			expected: "a1",
+		},
+		{
+			name:        "group quota",
+			maxParallel: 3,
+			maxPerGroup: 1,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", created: testDateTime(t, 1)},
+				{id: "b1", group: "b", created: testDateTime(t, 2)},
+			},
+			running:  []*runningJob{running("a", "test", true)},
+			expected: "b1",
+		},
+		{
+			name:        "action quota",
+			maxParallel: 3,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", action: "odoo", maxParallel: 1, created: testDateTime(t, 1)},
+				{id: "b1", group: "b", action: "test", created: testDateTime(t, 2)},
+			},
+			running:  []*runningJob{running("c", "odoo", false)},
+			expected: "b1",
+		},
+		{
+			name:        "remote action not started locally",
+			maxParallel: 3,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", action: "medias", remote: true, created: testDateTime(t, 1)},
+			},
+			expected: "",
+		},
+		{
+			name:        "worker limited to its actions",
+			maxParallel: 3,
+			pending: []*queuedJob{
+				{id: "a1", group: "a", action: "test", created: testDateTime(t, 1)},
+				{id: "b1", group: "b", action: "medias", remote: true, created: testDateTime(t, 2)},
+			},
+			actions:  []string{"medias"},
+			expected: "b1",
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			q := newJobQueue(s.maxParallel, s.maxPerGroup)
+			for _, item := range s.pending {
+				q.pending[item.id] = item
+			}
+			for i, job := range s.running {
+				q.running[strconv.Itoa(i)] = job
+			}
+			for group, turn := range s.served {
+				q.served[group] = turn
+			}
+
+			best, wait := q.best(s.actions)
+
+			id := ""
+			if best != nil {
+				id = best.id
+			}
+			if id != s.expected {
+				t.Fatalf("Expected job %q, got %q", s.expected, id)
+			}
+			if (wait > 0) != s.wait {
+				t.Fatalf("Expected wait %v, got %v", s.wait, wait)
+			}
+		})
+	}
+}
+
+func TestTranscodeQueuePosition(t *testing.T) {
+	q := newTranscodeQueue(1)
+	q.served = map[string]uint64{"a": 1}
+	q.turn = 1
+
+	for _, item := range []*queuedTranscode{
+		{id: "a1", group: "a", created: testDateTime(t, 1)},
+		{id: "a2", group: "a", created: testDateTime(t, 2)},
+		{id: "b1", group: "b", created: testDateTime(t, 3)},
+		{id: "b2", group: "b", created: testDateTime(t, 4)},
+		{id: "c1", group: "c", created: testDateTime(t, 5)},
+	} {
+		q.pending[item.id] = item
+	}
+
+	// b et c n'ont jamais été servis (b le plus ancien), puis les groupes alternent
+	scenarios := []struct {
+		id       string
+		expected int
+	}{
+		{"b1", 1},
+		{"c1", 2},
+		{"a1", 3},
+		{"b2", 4},
+		{"a2", 5},
+		{"missing", 0},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.id, func(t *testing.T) {
+			if position := q.position(s.id); position != s.expected {
+				t.Fatalf("Expected position %d, got %d", s.expected, position)
+			}
+		})
+	}
+
+	// la simulation ne modifie pas la file
+	if len(q.pending) != 5 || q.turn != 1 || q.served["a"] != 1 || len(q.served) != 1 {
+		t.Fatalf("Expected the queue to be unchanged, got %d pending, turn %d, served %v", len(q.pending), q.turn, q.served)
+	}
+}
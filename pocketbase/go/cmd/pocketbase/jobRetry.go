@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// JobAttempt est l'archive d'une tentative échouée d'un job
+type JobAttempt struct {
+	Attempt int    `json:"attempt"`
+	Started string `json:"started,omitempty"`
+	Ended   string `json:"ended"`
+	Error   string `json:"error,omitempty"`
+}
+
+// retryDelay calcule le délai avant la tentative suivante :
+// backoff exponentiel (base * 2^(attempt-1), plafonné) avec jitter sur la moitié du délai
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = envDuration("JOB_RETRY_BASE_DELAY", 10*time.Second)
+	}
+	maxDelay := envDuration("JOB_RETRY_MAX_DELAY", 15*time.Minute)
+	if maxDelay <= 0 {
+		maxDelay = 15 * time.Minute
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// canRetryJob indique si un job en échec a encore des tentatives
+func canRetryJob(job *core.Record) bool {
+	return max(job.GetInt("attempt"), 1) < getJobAction(job.GetString("action")).MaxAttempts
+}
+
+// retryJob remet en attente un job en échec s'il lui reste des tentatives.
+// La tentative échouée est archivée dans le champ attempts (ses logs restent dans job_logs).
+func retryJob(app *pocketbase.PocketBase, job *core.Record) {
+	logger := app.Logger()
+
+	action := getJobAction(job.GetString("action"))
+	attempt := max(job.GetInt("attempt"), 1)
+
+	if !canRetryJob(job) {
+		return
+	}
+
+	var attempts []JobAttempt
+	job.UnmarshalJSONField("attempts", &attempts)
+	attempts = append(attempts, JobAttempt{
+		Attempt: attempt,
+		Started: job.GetString("started"),
+		Ended:   time.Now().UTC().Format(time.RFC3339),
+		Error:   job.GetString("error"),
+	})
+
+	delay := retryDelay(action.RetryDelay, attempt)
+
+	job.Set("attempts", attempts)
+	job.Set("attempt", attempt+1)
+	job.Set("runAfter", time.Now().Add(delay))
+	job.Set("status", "pending")
+	job.Set("progress", 0)
+	job.Set("error", "")
+	job.Set("errorCode", "")
+	job.Set("result", nil)
+
+	if err := app.Save(job); err != nil {
+		logger.Error("❌ job retry failed", "id", job.Id, "err", err)
+		return
+	}
+
+	logger.Info("🔁 job retry scheduled", "id", job.Id, "attempt", attempt+1, "maxAttempts", action.MaxAttempts, "delay", delay)
+}
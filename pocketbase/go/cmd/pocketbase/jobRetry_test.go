@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	t.Setenv("JOB_RETRY_BASE_DELAY", "10s")
+	t.Setenv("JOB_RETRY_MAX_DELAY", "15m")
+
+	scenarios := []struct {
+		name     string
+		base     time.Duration
+		attempt  int
+		env      map[string]string // variables remplaçant celles du test
+		expected time.Duration     // délai avant jitter : le résultat est dans [expected/2, expected]
+	}{
+		{"first attempt", time.Second, 1, nil, time.Second},
+		{"attempt zero", time.Second, 0, nil, time.Second},
+		{"second attempt", time.Second, 2, nil, 2 * time.Second},
+		{"fifth attempt", time.Second, 5, nil, 16 * time.Second},
+		{"default base", 0, 1, nil, 10 * time.Second},
+		{"default base doubled", 0, 3, nil, 40 * time.Second},
+		{"capped", time.Minute, 10, nil, 15 * time.Minute},
+		{"base above cap", time.Hour, 1, nil, 15 * time.Minute},
+		{"many attempts", time.Second, 1000, nil, 15 * time.Minute},
+		{"zero max delay", time.Hour, 1, map[string]string{"JOB_RETRY_MAX_DELAY": "0s"}, 15 * time.Minute},
+		{"negative max delay", time.Hour, 1, map[string]string{"JOB_RETRY_MAX_DELAY": "-1m"}, 15 * time.Minute},
+		{"negative base", 0, 3, map[string]string{"JOB_RETRY_BASE_DELAY": "-10s"}, 0},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			for name, value := range s.env {
+				t.Setenv(name, value)
+			}
+
+			for i := 0; i < 100; i++ {
+				delay := retryDelay(s.base, s.attempt)
+				if delay < s.expected/2 || delay > s.expected {
+					t.Fatalf("Expected a delay in [%v, %v], got %v", s.expected/2, s.expected, delay)
+				}
+			}
+		})
+	}
+}
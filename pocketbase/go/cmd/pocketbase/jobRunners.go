@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// JobContext est le contrat commun à tous les runners : lecture du job,
+// remontée de la progression, du résultat et des logs.
+// Le contexte est annulé quand le job est annulé ou bloqué (watchdog).
+type JobContext struct {
+	context.Context
+
+	App    *pocketbase.PocketBase
+	Id     string
+	Group  string
+	Action string
+	Input  any
+	JSON   []byte // record du job sérialisé (argument des scripts)
+
+	Dir        string    // dossier de travail privé du job (cwd des process, supprimé à la fin)
+	InputFiles []string  // chemins locaux des fichiers du job (champ files), dans Dir/input
+	Limits     JobLimits // limites de ressources des process du job
+
+	Secrets map[string]string // secrets du groupe déclarés par l'action (variables d'environnement des process)
+	Env     []string          // variables du serveur déclarées par l'action, transmises en plus de jobBaseEnv
+
+	progress func(progress int)
+	result   func(result any)
+	log      func(level string, args ...any)
+	attach   func(file *filesystem.File) error
+	media    func(file *filesystem.File) (*core.Record, error)
+	usage    func(usage JobUsage)
+}
+
+// SetProgress met à jour la progression (0-100)
+func (jc *JobContext) SetProgress(progress int) {
+	jc.progress(progress)
+}
+
+// SetResult définit le résultat du job
+func (jc *JobContext) SetResult(result any) {
+	jc.result(result)
+}
+
+// Log ajoute une ligne de log (level : E, W, I ou D)
+func (jc *JobContext) Log(level string, args ...any) {
+	jc.log(level, args...)
+}
+
+// AddFile attache un fichier produit dans Dir au champ outputs du job. Les sorties ne vont pas dans files :
+// ce champ contient les fichiers d'entrée recopiés dans Dir à chaque tentative, elles y seraient reprises
+// en entrée et s'accumuleraient d'un retry à l'autre jusqu'à la limite du champ.
+func (jc *JobContext) AddFile(path string) error {
+	file, err := jc.outputFile(path)
+	if err != nil {
+		return err
+	}
+
+	return jc.attach(file)
+}
+
+// JobRunner exécute une action de job
+type JobRunner interface {
+	Run(jc *JobContext) error
+}
+
+// JobRunnerFunc est un runner implémenté par une fonction Go compilée dans le binaire
+type JobRunnerFunc func(jc *JobContext) error
+
+func (f JobRunnerFunc) Run(jc *JobContext) error {
+	return f(jc)
+}
+
+// DenoRunner exécute un script TypeScript avec Deno, limité aux permissions déclarées
+type DenoRunner struct {
+	Script   string
+	Manifest *JobManifest // permissions de l'action, aucune permission si nil
+}
+
+func (r DenoRunner) Run(jc *JobContext) error {
+	manifest := r.Manifest
+	if manifest == nil {
+		jc.Log("W", "no manifest for action", jc.Action, "running without permissions")
+		manifest = &JobManifest{}
+	}
+
+	// le script a toujours accès à son dossier de travail et à ses secrets, même non renseignés :
+	// Deno.env.get retourne alors undefined au lieu de lever une erreur de permission
+	env := append([]string{"JOB_DIR", "JOB_INPUT_FILES"}, manifest.Secrets...)
+
+	permissions, err := manifest.denoFlags(map[string][]string{
+		"read":  {jc.Dir},
+		"write": {jc.Dir},
+		"env":   env,
+	})
+	if err != nil {
+		return err
+	}
+
+	// le process tourne dans le dossier du job : chemin absolu du script
+	script, err := filepath.Abs(r.Script)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"run"}, permissions...)
+	if jc.Limits.Memory > 0 {
+		// tas V8 sous la limite mémoire du process, pour une erreur explicite plutôt qu'un kill
+		args = append(args, fmt.Sprintf("--v8-flags=--max-old-space-size=%d", jc.Limits.Memory*3/4))
+	}
+	args = append(args, script, string(jc.JSON))
+
+	cmd := exec.CommandContext(jc, "deno", args...)
+	return runJobProcess(jc, cmd)
+}
+
+// ExecRunner exécute un exécutable quelconque, qui reçoit le job en dernier argument
+type ExecRunner struct {
+	Path string
+	Args []string
+}
+
+func (r ExecRunner) Run(jc *JobContext) error {
+	path, err := filepath.Abs(r.Path)
+	if err != nil {
+		return err
+	}
+
+	args := append(append([]string{}, r.Args...), string(jc.JSON))
+	cmd := exec.CommandContext(jc, path, args...)
+	return runJobProcess(jc, cmd)
+}
+
+// defaultJobRunner résout le runner d'une action sans runner Go :
+// jobs/<action>.ts (Deno) ou, à défaut, l'exécutable jobs/<action>
+func defaultJobRunner(action string) JobRunner {
+	// sécurise le nom du script
+	action = filepath.Base(action)
+	script := filepath.Join("jobs", action+".ts")
+
+	if _, err := os.Stat(script); err != nil {
+		executable := filepath.Join("jobs", action)
+		if info, err := os.Stat(executable); err == nil && !info.IsDir() {
+			return ExecRunner{Path: executable}
+		}
+	}
+
+	manifest, _ := loadJobManifest(action)
+
+	return DenoRunner{Script: script, Manifest: manifest}
+}
+
+// jobBaseEnv sont les variables du serveur transmises à tous les process de job (en plus des LC_*)
+var jobBaseEnv = []string{"PATH", "HOME", "TMPDIR", "TZ", "LANG", "DENO_DIR"}
+
+// jobDeniedEnv ne sont jamais transmises, même déclarées par un manifest :
+// elles donnent accès aux secrets de tous les groupes ou aux routes des workers
+var jobDeniedEnv = []string{"SECRETS_KEY", "WORKER_TOKEN"}
+
+// jobProcessEnv construit l'environnement d'un process de job : variables de base et déclarées
+// par l'action, secrets du groupe, puis JOB_DIR et JOB_INPUT_FILES. Le reste de l'environnement
+// du serveur n'est pas transmis.
+func jobProcessEnv(jc *JobContext, inputFiles []byte) []string {
+	env := []string{}
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if containsString(jobDeniedEnv, name) {
+			continue
+		}
+		if containsString(jobBaseEnv, name) || strings.HasPrefix(name, "LC_") || containsString(jc.Env, name) {
+			env = append(env, entry)
+		}
+	}
+
+	for name, value := range jc.Secrets {
+		env = append(env, name+"="+value)
+	}
+
+	return append(env,
+		"JOB_DIR="+jc.Dir,
+		"JOB_INPUT_FILES="+string(inputFiles),
+	)
+}
+
+// runJobProcess lance le process d'un job et traduit sa sortie selon le protocole tabulé :
+// stdout "progress\t<n>", "result\t<json>", "file\t<path>[\tmedia]", "E|W|I|D\t<args...>" ;
+// stderr est loggé en erreur. Le dossier de travail (cwd du process) et les fichiers d'entrée
+// sont passés par les variables JOB_DIR et JOB_INPUT_FILES (tableau JSON de chemins),
+// les secrets par des variables à leur nom (environnement restreint, voir jobProcessEnv).
+func runJobProcess(jc *JobContext, cmd *exec.Cmd) error {
+	inputFiles, _ := json.Marshal(jc.InputFiles)
+	cmd.Dir = jc.Dir
+	cmd.Env = jobProcessEnv(jc, inputFiles)
+
+	// Préparation des pipes stdout / stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// Arrêt du groupe de process : SIGTERM, puis SIGKILL après le délai de grâce
+	setupJobProcess(cmd, envDuration("JOB_KILL_GRACE", defaultJobKillGrace))
+
+	// Limites de ressources appliquées avant l'exec du process
+	if err := wrapJobLimits(cmd, jc.Limits); err != nil {
+		return fmt.Errorf("failed to apply resource limits: %w", err)
+	}
+
+	// Lancement du processus
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer killJobProcessGroup(cmd)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Traitement de la sortie standard (stdout)
+	go func() {
+		defer wg.Done()
+
+		stdoutReader := bufio.NewReader(stdout)
+
+		for {
+			// Lecture d'une ligne depuis stdout
+			line, err := stdoutReader.ReadString('\n')
+			if line != "" {
+				handleJobMessage(jc, strings.TrimRight(line, "\r\n"))
+			}
+			if err != nil {
+				// En cas d'erreur autre que EOF, on log l'erreur
+				if err.Error() != "EOF" {
+					jc.Log("E", "stdout reader error", err.Error())
+				}
+				break
+			}
+		}
+	}()
+
+	// Traitement de la sortie d'erreur (stderr)
+	go func() {
+		defer wg.Done()
+
+		reader := bufio.NewReader(stderr)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				jc.Log("E", strings.TrimRight(line, "\r\n"))
+			}
+			if err != nil {
+				if err.Error() != "EOF" {
+					jc.Log("E", "stderr reader error", err.Error())
+				}
+				break
+			}
+		}
+	}()
+
+	// Attente de fin des goroutines de lecture, avant Wait qui ferme les pipes
+	wg.Wait()
+
+	// Attente de la fin du processus
+	err = cmd.Wait()
+
+	if usage, ok := jobProcessUsage(cmd); ok && jc.usage != nil {
+		jc.usage(usage)
+	}
+
+	return err
+}
+
+// handleJobMessage interprète une ligne du protocole tabulé
+func handleJobMessage(jc *JobContext, line string) {
+	// Découpage de la ligne en éléments tabulés
+	fields := strings.Split(line, "\t")
+
+	// S'il n'y a pas au moins une propriété et une valeur, ignorer la ligne
+	if len(fields) <= 1 {
+		jc.Log("D", line)
+		return
+	}
+
+	// Le premier champ est la "clé" ou type de message
+	messageType := fields[0]
+	rawValues := fields[1:]
+
+	// Pour les cas comme "progress" ou "result", on prend la première valeur
+	rawValue := rawValues[0]
+
+	args := make([]any, len(rawValues))
+	for i, v := range rawValues {
+		args[i] = v
+	}
+
+	// Gestion en fonction du type de message
+	switch messageType {
+	case "progress":
+		if num, ok := parse(rawValue).(float64); ok {
+			jc.SetProgress(int(math.Round(num)))
+		} else {
+			jc.Log("E", "invalid progress value", rawValue)
+		}
+
+	case "result":
+		jc.App.Logger().Info("job result", "id", jc.Id, "result", rawValue)
+		jc.SetResult(parse(rawValue))
+
+	case "file":
+		var err error
+		if len(rawValues) > 1 && rawValues[1] == "media" {
+			var media *core.Record
+			if media, err = jc.AddMedia(rawValue); err == nil {
+				jc.Log("I", "media created", media.Id, media.GetString("name"))
+			}
+		} else {
+			err = jc.AddFile(rawValue)
+		}
+		if err != nil {
+			jc.Log("E", "invalid file", rawValue, err.Error())
+		}
+
+	case "E", "W", "I", "D":
+		jc.Log(messageType, args...)
+
+	default:
+		jc.Log("D", args...)
+	}
+}
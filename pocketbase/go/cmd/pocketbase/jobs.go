@@ -1,28 +1,37 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
-	"os/exec"
-	"path/filepath"
-	"strings"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
 )
 
 const (
-	maxParallelJobs = 3 // Max de jobs exécutés simultanément
-	timeoutSecond   = 10 * time.Second
+	maxParallelJobs         = 3                // Max de jobs exécutés simultanément
+	maxParallelJobsPerGroup = 2                // Max de jobs exécutés simultanément pour un même groupe
+	timeoutSecond           = 10 * time.Second // Inactivité maximale par défaut (sans progress, result ni log)
+	defaultJobTimeout       = time.Hour        // Durée maximale par défaut d'une exécution
+	defaultJobKillGrace     = 10 * time.Second // Délai entre SIGTERM et SIGKILL à l'arrêt d'un job
+
+	jobErrorTimeout = "timeout" // errorCode d'un job arrêté par son timeout ou son inactivité
 )
 
-var jobSemaphore = make(chan struct{}, maxParallelJobs) // Sémaphore de contrôle de concurrence
+var (
+	// errJobInactive est la cause d'arrêt d'un job resté sans update au-delà de son délai d'inactivité
+	errJobInactive = errors.New("no update within timeout")
+	// errJobTimeout est la cause d'arrêt d'un job ayant dépassé le timeout de son action
+	errJobTimeout = errors.New("timeout exceeded")
+)
 
 type Interval struct {
 	stop chan struct{}
@@ -63,50 +72,69 @@ func parse(str string) any {
 	return result
 }
 
+// JobRunOptions adapte l'exécution d'un job hors de la file (commande jobs run, worker distant)
+type JobRunOptions struct {
+	NoRecord bool                            // n'enregistre ni le job, ni ses logs, ni ses fichiers
+	OnLog    func(level string, args ...any) // reçoit chaque ligne de log
+	OnUpdate func(key string, val any)       // reçoit chaque modification du job (progress, result, ...)
+
+	// OnFile reçoit les fichiers produits sans record (media : AddMedia), à la place du stockage local
+	OnFile func(file *filesystem.File, media bool) (*core.Record, error)
+	// FetchFile récupère un fichier d'entrée du job (champ files) à la place du stockage local
+	FetchFile func(name string, path string) error
+	// Secrets déjà déchiffrés (workers), à la place des secrets du groupe lus dans la base
+	Secrets map[string]string
+}
+
 // startJob exécute un job en backend (appelé à la création du record)
-func startJob(app *pocketbase.PocketBase, job *core.Record) {
+// L'annulation de ctx tue le process et termine le job en "cancelled".
+func startJob(ctx context.Context, app *pocketbase.PocketBase, job *core.Record, opts JobRunOptions) {
 	logger := app.Logger()
 
 	// 🔐 Mutex pour les accès concurrents
 	var (
 		mu          sync.Mutex
 		isUpdate    atomic.Bool
-		isLogUpdate atomic.Bool
 		lastUpdated atomic.Int64
-		logs        strings.Builder
+		logs        = newJobLogWriter(app, job)
 	)
 	isUpdate.Store(false)
-	isLogUpdate.Store(false)
 
-	// Utilitaires thread-safe pour modifier l'état du job
-	set := func(key string, val any) {
+	// Utilitaires thread-safe pour modifier l'état du job.
+	// update et write ne comptent pas comme une activité du job (watchdog),
+	// contrairement à set et log, utilisés pour ce que remonte le job.
+	update := func(key string, val any) {
 		mu.Lock()
 
 		job.Set(key, val)
 
 		mu.Unlock()
 
+		if opts.OnUpdate != nil {
+			opts.OnUpdate(key, val)
+		}
+
 		isUpdate.Store(true)
-		lastUpdated.Store(time.Now().UnixNano())
 	}
 
-	log := func(level string, args ...any) {
-		mu.Lock()
-
-		logs.WriteString(level)
+	set := func(key string, val any) {
+		update(key, val)
+		lastUpdated.Store(time.Now().UnixNano())
+	}
 
-		for _, arg := range args {
-			logs.WriteString("\t")
-			logs.WriteString(fmt.Sprint(arg))
+	write := func(level string, args ...any) {
+		if opts.OnLog != nil {
+			opts.OnLog(level, args...)
+		}
+		if !opts.NoRecord {
+			logs.add(level, args...)
 		}
-
-		logs.WriteString("\n")
-
-		mu.Unlock()
-
 		isUpdate.Store(true)
+	}
+
+	log := func(level string, args ...any) {
+		write(level, args...)
 		lastUpdated.Store(time.Now().UnixNano())
-		isLogUpdate.Store(true)
 	}
 
 	// Synchronise les modifications du job avec la base
@@ -116,19 +144,21 @@ func startJob(app *pocketbase.PocketBase, job *core.Record) {
 		}
 		isUpdate.Store(false)
 
-		if isLogUpdate.Load() {
-			set("logs", logs.String())
-			isLogUpdate.Store(false)
+		if opts.NoRecord {
+			return
 		}
 
-		log("T", time.Now().Format(time.RFC3339))
+		// Lignes de log dans job_logs (le record du job n'est plus réécrit avec tous les logs)
+		if err := logs.flush(); err != nil {
+			logger.Error("❌ job logs flush failed", "id", job.Id, "err", err)
+		}
 
 		mu.Lock()
 
 		// Sauvegarde sécurisée du record avec timestamp
 		if err := app.Save(job); err != nil {
 			logger.Error("❌ job flushState failed", "id", job.Id, "err", err)
-			job.Set("logs", nil)
+			job.Set("outputs", job.Original().Get("outputs")) // abandonne les fichiers non sauvegardés
 			job.Set("result", nil)
 			job.Set("status", "failed")
 			job.Set("error", err.Error())
@@ -144,8 +174,17 @@ func startJob(app *pocketbase.PocketBase, job *core.Record) {
 	logger.Info("▶️ job started", "id", job.Id)
 	set("status", "processing")
 	set("progress", 1)
+	set("started", time.Now())
+	set("outputs", nil) // fichiers produits par une tentative précédente
 	flushState()
 
+	if err := setDependencyResults(app, job); err != nil {
+		log("E", "failed to load dependency results", err.Error())
+		set("status", "failed")
+		set("error", err.Error())
+		return
+	}
+
 	jobJSON, err := job.MarshalJSON()
 	if err != nil {
 		log("E", "failed to marshal job", err.Error())
@@ -154,166 +193,147 @@ func startJob(app *pocketbase.PocketBase, job *core.Record) {
 		return
 	}
 
-	// sécurise le nom du script
-	action := filepath.Base(job.GetString("action"))
-	script := filepath.Join("jobs", action+".ts")
-
-	// process avec contexte
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	action := getJobAction(job.GetString("action"))
 
-	cmd := exec.CommandContext(ctx, "deno", "run", "--allow-all", script, string(jobJSON))
-
-	// Démarre le watchdog qui surveille les updates
-	timer := startInterval(func() {
-		if time.Now().UnixNano()-lastUpdated.Load() > int64(timeoutSecond) {
+	secrets := opts.Secrets
+	if secrets == nil {
+		secrets, err = loadJobSecrets(app, job.GetString("group"), action.Secrets)
+		if err != nil {
+			logger.Error("❌ job secrets failed", "id", job.Id, "err", err)
 			set("status", "failed")
-			set("error", "No update within timeout")
-			cmd.Process.Kill()
+			set("error", err.Error())
+			return
 		}
-
-		flushState()
-	}, 2000)
-	defer stopInterval(timer)
-
-	// Préparation des pipes stdout / stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Error("❌ failed to get stdout", "id", job.Id, "err", err)
-		set("status", "failed")
-		set("error", "stdout pipe error")
-		return
 	}
 
-	stderr, err := cmd.StderrPipe()
+	// dossier de travail privé, avec les fichiers d'entrée du job
+	dir, inputFiles, err := prepareJobDir(app, job, opts.FetchFile)
+	if dir != "" {
+		// les fichiers produits doivent être sauvegardés avant la suppression du dossier
+		defer func() {
+			flushState()
+			if opts.NoRecord && opts.OnFile == nil {
+				// sans record, les fichiers produits ne sont pas sauvegardés : le dossier est conservé
+				write("I", "job directory kept", dir)
+				return
+			}
+			os.RemoveAll(dir)
+		}()
+	}
 	if err != nil {
-		logger.Error("❌ failed to get stderr", "id", job.Id, "err", err)
+		logger.Error("❌ job directory failed", "id", job.Id, "err", err)
 		set("status", "failed")
-		set("error", "stderr pipe error")
+		set("error", err.Error())
 		return
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// Traitement de la sortie standard (stdout)
-	go func() {
-		defer wg.Done()
-
-		stdoutReader := bufio.NewReader(stdout)
-
-		for {
-			// Lecture d'une ligne depuis stdout
-			line, err := stdoutReader.ReadString('\n')
-			if err != nil {
-				// En cas d'erreur autre que EOF, on log l'erreur
-				if err.Error() != "EOF" {
-					log("E", "stdout reader error", err.Error())
-				}
-				break
-			}
-
-			// Nettoyage de la fin de ligne
-			line = strings.TrimRight(line, "\r\n")
-
-			// Découpage de la ligne en éléments tabulés
-			fields := strings.Split(line, "\t")
-
-			// S'il n'y a pas au moins une propriété et une valeur, ignorer la ligne
-			if len(fields) <= 1 {
-				log("D", line)
-				continue
-			}
-
-			// Le premier champ est la "clé" ou type de message
-			messageType := fields[0]
-			rawValues := fields[1:]
-
-			// Pour les cas comme "progress" ou "result", on prend la première valeur
-			var rawValue string
-			if len(rawValues) > 0 {
-				rawValue = rawValues[0]
+	// contexte d'exécution, annulé par une demande d'annulation, par le watchdog ou par le timeout de l'action
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	var cancelTimeout context.CancelFunc
+	runCtx, cancelTimeout = context.WithTimeoutCause(runCtx, action.Timeout, errJobTimeout)
+	defer cancelTimeout()
+
+	jc := &JobContext{
+		Context:    runCtx,
+		App:        app,
+		Id:         job.Id,
+		Group:      job.GetString("group"),
+		Action:     job.GetString("action"),
+		Input:      job.Get("input"),
+		JSON:       jobJSON,
+		Dir:        dir,
+		InputFiles: inputFiles,
+		Limits:     action.Limits,
+		Secrets:    secrets,
+		Env:        action.Env,
+		progress:   func(progress int) { set("progress", progress) },
+		result:     func(result any) { set("result", result) },
+		log:        log,
+		attach: func(file *filesystem.File) error {
+			switch {
+			case opts.OnFile != nil:
+				_, err := opts.OnFile(file, false)
+				return err
+			case opts.NoRecord:
+				log("I", "file not stored (no record)", file.OriginalName)
+			default:
+				set("outputs+", file)
 			}
-
-			// Gestion en fonction du type de message
-			switch messageType {
-			case "progress":
-				if num, ok := parse(rawValue).(float64); ok {
-					set("progress", int(math.Round(num)))
-				} else {
-					log("E", "invalid progress value", rawValue)
-				}
-
-			case "result":
-				logger.Info("job result", "id", job.Id, "result", rawValue)
-				set("result", parse(rawValue))
-
-			case "E", "W", "I", "D":
-				args := make([]any, len(rawValues))
-				for i, v := range rawValues {
-					args[i] = v
-				}
-				log(messageType, args...)
-
+			return nil
+		},
+		media: func(file *filesystem.File) (*core.Record, error) {
+			switch {
+			case opts.OnFile != nil:
+				return opts.OnFile(file, true)
+			case opts.NoRecord:
+				return nil, fmt.Errorf("media not created (no record)")
 			default:
-				args := make([]any, len(rawValues))
-				for i, v := range rawValues {
-					args[i] = v
-				}
-				log("D", args...)
+				return createMedia(app, job.GetString("group"), file)
 			}
+		},
+		usage: func(usage JobUsage) {
+			update("peakMemory", usage.PeakMemory)
+			update("cpuTime", usage.CPUTime.Milliseconds())
+		},
+	}
+
+	// Démarre le watchdog qui surveille l'activité du job (progress, result, logs)
+	timer := startInterval(func() {
+		if time.Now().UnixNano()-lastUpdated.Load() > int64(action.Inactivity) {
+			stop(errJobInactive)
 		}
-	}()
 
-	// Traitement de la sortie d'erreur (stderr)
-	go func() {
-		defer wg.Done()
+		flushState()
+	}, 2000)
+	defer stopInterval(timer)
 
-		reader := bufio.NewReader(stderr)
+	runner := action.Runner
+	if runner == nil {
+		runner = defaultJobRunner(jc.Action)
+	}
 
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err.Error() != "EOF" {
-					log("E", "stderr reader error", err.Error())
-				}
-				break
-			}
+	err = runner.Run(jc)
 
-			line = strings.TrimRight(line, "\r\n")
+	switch {
+	case isInterrupted(ctx):
+		// arrêt du serveur : le job sera relancé au démarrage suivant
+		logger.Warn("⏸️ job interrupted by shutdown", "id", job.Id)
+		log("W", "job interrupted by server shutdown")
+		set("status", "pending")
+		set("progress", 0)
 
-			log("E", line)
-		}
-	}()
+	case ctx.Err() != nil:
+		logger.Info("🛑 job cancelled", "id", job.Id)
+		log("W", "job cancelled")
+		set("status", "cancelled")
+		set("error", "Cancelled")
 
-	// Lancement du processus
-	if err := cmd.Start(); err != nil {
-		logger.Error("❌ failed to start job", "id", job.Id, "err", err)
+	case context.Cause(runCtx) == errJobInactive:
+		logger.Error("❌ job inactive", "id", job.Id, "inactivity", action.Inactivity)
 		set("status", "failed")
-		set("error", err.Error())
-		return
-	}
+		set("error", fmt.Sprintf("No update within %s", action.Inactivity))
+		set("errorCode", jobErrorTimeout)
+
+	case context.Cause(runCtx) == errJobTimeout:
+		logger.Error("❌ job timeout", "id", job.Id, "timeout", action.Timeout)
+		set("status", "failed")
+		set("error", fmt.Sprintf("Timeout after %s", action.Timeout))
+		set("errorCode", jobErrorTimeout)
 
-	// Attente de la fin du processus
-	if err := cmd.Wait(); err != nil {
+	case err != nil:
 		logger.Error("❌ job process failed", "id", job.Id, "error", err)
 		set("status", "failed")
 		set("error", err.Error())
-		wg.Wait()
-		return
-	}
-
-	// Attente de fin des goroutines de lecture
-	wg.Wait()
 
-	// Petite pause pour capter les logs restants
-	time.Sleep(100 * time.Millisecond)
+	default:
+		// Finalisation
+		set("status", "finished")
+		set("progress", 100)
 
-	// Finalisation
-	set("status", "finished")
-	set("progress", 100)
-
-	logger.Info("✅ job finished", "id", job.Id)
+		logger.Info("✅ job finished", "id", job.Id)
+	}
 }
 
 func safeGo(fn func()) {
@@ -330,32 +350,81 @@ func safeGo(fn func()) {
 func handleJob(app *pocketbase.PocketBase, job *core.Record) {
 	app.Logger().Debug("handleJob", "id", job.Id, "status", job.GetString("status"))
 
-	if job.GetString("status") != "" { // déjà traité
+	switch job.GetString("status") {
+	case "": // nouveau job (ou relancé) : on le persiste en attente
+		job.Set("status", "pending")
+		job.Set("progress", 0)
+		job.Set("error", "")
+		job.Set("errorCode", "")
+		job.Set("peakMemory", nil)
+		job.Set("cpuTime", nil)
+		job.Set("result", nil)
+		job.Set("attempt", 1)
+		job.Set("attempts", nil)
+		job.Set("runAfter", nil)
+		job.Set("outputs", nil)
+
+		if err := app.Save(job); err != nil {
+			app.Logger().Error("❌ failed to queue job", "id", job.Id, "err", err)
+			return
+		}
+
+		if err := deleteJobLogs(app, job); err != nil {
+			app.Logger().Error("❌ failed to delete job logs", "id", job.Id, "err", err)
+		}
+
+	case "pending": // déjà en attente (création directe ou reprise)
+	case "finished", "failed", "cancelled", "skipped": // terminé : débloque les jobs qui en dépendent
+		resolveDependents(app, job)
+		return
+	default: // en cours
 		return
 	}
 
-	job.Set("logs", nil)
-	job.Set("result", nil)
+	if waitDependencies(app, job) {
+		return
+	}
 
-	// jobCopy := job.Clone()
-	// jobCopy.MarkAsNotNew()
+	app.Logger().Info("⏳ wait slot", "id", job.Id)
+	jobQueue.push(job)
+}
 
-	safeGo(func() {
-		// 🔒 Contrôle de concurrence : limite le nombre de jobs actifs
-		app.Logger().Info("⏳ wait slot", "id", job.Id)
-		jobSemaphore <- struct{}{}
-		defer func() { <-jobSemaphore }()
-		app.Logger().Debug("🚀 slot ok", "id", job.Id)
+// jobSchedulingFields sont les champs gérés par l'ordonnanceur, que seul un superuser peut modifier
+var jobSchedulingFields = []string{"status", "worker", "leaseExpires", "attempt", "runAfter", "started"}
 
-		startJob(app, job)
-	})
+// checkJobUpdateRequest rejette la modification des champs d'ordonnancement par un client
+// (seul le passage du statut d'un job terminé à "" reste permis : relance du job)
+func checkJobUpdateRequest(e *core.RecordRequestEvent) error {
+	if e.HasSuperuserAuth() {
+		return e.Next()
+	}
 
-	// Attendre un peu pour les jobs rapide (obtenir directement le resultat)
-	time.Sleep(2000)
+	original := e.Record.Original()
+	for _, name := range jobSchedulingFields {
+		if e.Record.GetString(name) == original.GetString(name) {
+			continue
+		}
+		if name == "status" && e.Record.GetString(name) == "" && isJobDone(original) {
+			continue
+		}
+		return e.JSON(403, errorJSON("Field %s is managed by the scheduler", name))
+	}
+
+	return e.Next()
 }
 
 // bindJobs attache le handler sur création de job
 func bindJobs(app *pocketbase.PocketBase) {
+	// Validation de l'input selon le manifest de l'action
+	app.OnRecordCreateRequest("jobs").BindFunc(checkJobRequest)
+	app.OnRecordUpdateRequest("jobs").BindFunc(checkJobRequest)
+
+	// Les champs d'ordonnancement ne sont modifiés que par le serveur
+	app.OnRecordUpdateRequest("jobs").BindFunc(checkJobUpdateRequest)
+
+	// Déduplication par clé d'idempotence (double-clics, retries des clients)
+	app.OnRecordCreateRequest("jobs").BindFunc(dedupeJobRequest)
+
 	app.OnRecordAfterCreateSuccess("jobs").BindFunc(func(e *core.RecordEvent) error {
 		handleJob(app, e.Record)
 		return e.Next()
@@ -365,4 +434,15 @@ func bindJobs(app *pocketbase.PocketBase) {
 		handleJob(app, e.Record)
 		return e.Next()
 	})
+
+	// Reprise de la file persistante au démarrage du serveur
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		recoverJobs(app)
+		go jobQueue.run(app)
+
+		se.Router.POST("/api/jobs/run", runJob).Bind(apis.RequireAuth())
+		se.Router.POST("/api/jobs/{id}/cancel", cancelJob).Bind(apis.RequireAuth())
+
+		return se.Next()
+	})
 }
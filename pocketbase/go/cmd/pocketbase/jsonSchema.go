@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validateJSONSchema valide value (décodé par encoding/json) contre un sous-ensemble de JSON Schema :
+// type, enum, const, required, properties, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum, maximum.
+// path sert à localiser l'erreur (ex: "input.products[2].price").
+func validateJSONSchema(schema map[string]any, value any, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		actual := jsonType(value)
+		ok := false
+		for _, t := range types {
+			if t == actual || (t == "number" && actual == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), actual)
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			if reflect.DeepEqual(v, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allowed, _ := json.Marshal(enum)
+			return fmt.Errorf("%s: must be one of %s", path, allowed)
+		}
+	}
+
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		expected, _ := json.Marshal(constant)
+		return fmt.Errorf("%s: must be %s", path, expected)
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if min, ok := schemaNumber(schema["minLength"]); ok && float64(length) < min {
+			return fmt.Errorf("%s: must be at least %v characters", path, min)
+		}
+		if max, ok := schemaNumber(schema["maxLength"]); ok && float64(length) > max {
+			return fmt.Errorf("%s: must be at most %v characters", path, max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid schema pattern %q", path, pattern)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: must match %q", path, pattern)
+			}
+		}
+
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && v < min {
+			return fmt.Errorf("%s: must be >= %v", path, min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && v > max {
+			return fmt.Errorf("%s: must be <= %v", path, max)
+		}
+
+	case []any:
+		if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: must have at least %v items", path, min)
+		}
+		if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: must have at most %v items", path, max)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateJSONSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, exists := v[key]; !exists {
+						return fmt.Errorf("%s.%s: is required", path, key)
+					}
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]any)
+
+		// ordre stable pour des messages d'erreur reproductibles
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if property, ok := properties[key].(map[string]any); ok {
+				if err := validateJSONSchema(property, v[key], path+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s.%s: is not allowed", path, key)
+				}
+			case map[string]any:
+				if err := validateJSONSchema(additional, v[key], path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// schemaTypes normalise le mot-clé "type" (chaîne ou liste de chaînes)
+func schemaTypes(raw any) []string {
+	switch t := raw.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func schemaNumber(raw any) (float64, bool) {
+	n, ok := raw.(float64)
+	return n, ok
+}
+
+// jsonType retourne le type JSON Schema d'une valeur décodée
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
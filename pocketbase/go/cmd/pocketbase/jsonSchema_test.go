@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		schema   string
+		value    string
+		expected string // message d'erreur attendu, "" si valide
+	}{
+		{"empty schema", `{}`, `{"a":1}`, ""},
+		{"type match", `{"type":"string"}`, `"a"`, ""},
+		{"type mismatch", `{"type":"string"}`, `1`, "input: expected string, got integer"},
+		{"type list", `{"type":["object","null"]}`, `null`, ""},
+		{"type list mismatch", `{"type":["object","null"]}`, `[]`, "input: expected object or null, got array"},
+		{"integer is a number", `{"type":"number"}`, `2`, ""},
+		{"number is not an integer", `{"type":"integer"}`, `2.5`, "input: expected integer, got number"},
+		{"enum match", `{"enum":["a","b"]}`, `"b"`, ""},
+		{"enum mismatch", `{"enum":["a","b"]}`, `"c"`, `input: must be one of ["a","b"]`},
+		{"const mismatch", `{"const":{"a":1}}`, `{"a":2}`, `input: must be {"a":1}`},
+		{"minLength", `{"minLength":3}`, `"ab"`, "input: must be at least 3 characters"},
+		{"maxLength counts runes", `{"maxLength":2}`, `"éé"`, ""},
+		{"maxLength", `{"maxLength":2}`, `"abc"`, "input: must be at most 2 characters"},
+		{"pattern match", `{"pattern":"^[a-z]+$"}`, `"abc"`, ""},
+		{"pattern mismatch", `{"pattern":"^[a-z]+$"}`, `"ab1"`, `input: must match "^[a-z]+$"`},
+		{"invalid pattern", `{"pattern":"("}`, `"a"`, `input: invalid schema pattern "("`},
+		{"minimum", `{"minimum":0}`, `-1`, "input: must be >= 0"},
+		{"maximum", `{"maximum":10}`, `10.5`, "input: must be <= 10"},
+		{"minItems", `{"minItems":1}`, `[]`, "input: must have at least 1 items"},
+		{"maxItems", `{"maxItems":1}`, `[1,2]`, "input: must have at most 1 items"},
+		{
+			"items path",
+			`{"items":{"type":"object","properties":{"price":{"type":"number"}}}}`,
+			`[{"price":1},{"price":2},{"price":"x"}]`,
+			"input[2].price: expected number, got string",
+		},
+		{"required", `{"required":["id"]}`, `{}`, "input.id: is required"},
+		{"additional properties allowed", `{"properties":{"a":{}}}`, `{"b":1}`, ""},
+		{"additional properties denied", `{"properties":{"a":{}},"additionalProperties":false}`, `{"a":1,"b":1}`, "input.b: is not allowed"},
+		{"additional properties schema", `{"additionalProperties":{"type":"string"}}`, `{"a":"x","b":1}`, "input.b: expected string, got integer"},
+		{"first error in key order", `{"additionalProperties":false}`, `{"z":1,"a":1}`, "input.a: is not allowed"},
+		{
+			"nested object",
+			`{"type":"object","required":["products"],"properties":{"products":{"type":"array","items":{"required":["id"]}}}}`,
+			`{"products":[{"id":1},{}]}`,
+			"input.products[1].id: is required",
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			var schema map[string]any
+			if err := json.Unmarshal([]byte(s.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			var value any
+			if err := json.Unmarshal([]byte(s.value), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			err := validateJSONSchema(schema, value, "input")
+
+			message := ""
+			if err != nil {
+				message = err.Error()
+			}
+			if message != s.expected {
+				t.Fatalf("Expected error %q, got %q", s.expected, message)
+			}
+		})
+	}
+}
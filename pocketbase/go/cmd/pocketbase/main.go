@@ -2,15 +2,34 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/pocketbase/pocketbase"
 )
 
 func main() {
+	// Lanceur d'un process de job avec ses limites de ressources (voir wrapJobLimits)
+	if len(os.Args) > 1 && os.Args[1] == jobLimitsCommand {
+		err := execWithJobLimits(os.Args[2:])
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(126)
+	}
+
 	app := pocketbase.New()
 
 	bindMedias(app)
 	bindServe(app)
 	bindJobs(app)
+	bindSecrets(app)
+	bindSchedules(app)
+	bindAutomations(app)
+	bindTaskEvents(app)
+	bindJanitor(app)
+	bindShutdown(app)
+	bindJobCommands(app)
+	bindWorkerLeases(app)
+	bindWorkerCommand(app)
 
 	// Bind du transcodage vidéo
 	bindTranscode(app)
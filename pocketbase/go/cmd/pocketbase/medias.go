@@ -4,6 +4,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
@@ -162,14 +163,21 @@ func processMediaFile(e *core.RecordRequestEvent) error {
 
 	logger.Info("📦 Processing media file", "filename", file.OriginalName, "size", file.Size)
 
-	// Toujours mettre à jour size et type
-	media.Set("size", file.Size)
-
 	// Si le nom est vide, utiliser le nom du fichier
 	if media.GetString("name") == "" {
 		media.Set("name", file.Name)
 	}
 
+	updateMediaInfo(logger, media, file)
+
+	return e.Next()
+}
+
+// updateMediaInfo met à jour size, type et data (dimensions, durée, ffprobe) d'un media
+func updateMediaInfo(logger *slog.Logger, media *core.Record, file *filesystem.File) {
+	// Toujours mettre à jour size et type
+	media.Set("size", file.Size)
+
 	// Détecter le mime type
 	mimeType := getMimeType(logger, file)
 	media.Set("type", mimeType)
@@ -194,8 +202,83 @@ func processMediaFile(e *core.RecordRequestEvent) error {
 	if mediaData != nil {
 		media.Set("data", mediaData)
 	}
+}
 
-	return e.Next()
+// runMediasJob (action "medias") recalcule les infos des medias du groupe,
+// ou seulement de ceux listés dans input.medias
+func runMediasJob(jc *JobContext) error {
+	app := jc.App
+	logger := app.Logger()
+
+	if jc.Group == "" {
+		return fmt.Errorf("no group")
+	}
+
+	var input struct {
+		Medias []string `json:"medias"`
+	}
+	if raw, err := json.Marshal(jc.Input); err == nil {
+		json.Unmarshal(raw, &input)
+	}
+
+	var medias []*core.Record
+	var err error
+
+	if len(input.Medias) > 0 {
+		medias, err = app.FindRecordsByIds("medias", input.Medias)
+	} else {
+		medias, err = app.FindRecordsByFilter("medias", "group = {:group}", "created", 0, 0, map[string]any{
+			"group": jc.Group,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	processed, failed := 0, 0
+
+	for i, media := range medias {
+		if err := jc.Err(); err != nil {
+			return err
+		}
+
+		fileName := media.GetString("file")
+		if media.GetString("group") != jc.Group || fileName == "" {
+			continue
+		}
+
+		file, err := fsys.GetReuploadableFile(media.BaseFilesPath()+"/"+fileName, true)
+		if err != nil {
+			jc.Log("E", "media file not found", media.Id, err.Error())
+			failed++
+			continue
+		}
+
+		updateMediaInfo(logger, media, file)
+
+		if err := app.Save(media); err != nil {
+			jc.Log("E", "media save failed", media.Id, err.Error())
+			failed++
+			continue
+		}
+
+		jc.Log("I", "media updated", media.Id, media.GetString("type"))
+		jc.SetProgress(1 + (i+1)*98/len(medias))
+		processed++
+	}
+
+	jc.SetResult(map[string]int{
+		"processed": processed,
+		"failed":    failed,
+	})
+
+	return nil
 }
 
 func bindMedias(app *pocketbase.PocketBase) {
@@ -48,7 +48,7 @@ func pairDevice(e *core.RequestEvent) error {
 	device.Set("group", groupId)
 
 	if err := app.Save(device); err != nil {
-		log.Error("Failed to pair device, error: %s", err.Error())
+		log.Error("Failed to pair device", "err", err)
 		return e.JSON(500, errorJSON("Failed to pair device"))
 	}
 
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	defaultRunJobWait = 10 * time.Second // Wait used when the client gives no timeout
+	maxRunJobWait     = 60 * time.Second // Longest wait allowed (JOB_RUN_MAX_WAIT)
+)
+
+// runJobBody is the body of POST /api/jobs/run
+type runJobBody struct {
+	Action         string          `json:"action"`
+	Group          string          `json:"group"`
+	Input          json.RawMessage `json:"input"`
+	Priority       int             `json:"priority"`
+	DependsOn      []string        `json:"dependsOn"`
+	IdempotencyKey string          `json:"idempotencyKey"` // returns the existing job with the same key instead of a new one
+	Timeout        float64         `json:"timeout"`        // seconds to wait for the result
+}
+
+// isJobDone reports whether a job reached its final state (a failed job with retries left is not done)
+func isJobDone(job *core.Record) bool {
+	switch job.GetString("status") {
+	case "failed":
+		return !canRetryJob(job)
+	case "finished", "cancelled", "skipped", "deleted":
+		return true
+	}
+	return false
+}
+
+// runJob creates a job and waits for it up to the client timeout:
+// 200 with the result if it is done in time, 202 with the job id otherwise
+func runJob(e *core.RequestEvent) error {
+	app := e.App
+	log := app.Logger()
+
+	body := runJobBody{}
+	if err := e.BindBody(&body); err != nil {
+		return e.JSON(400, errorJSON("Invalid body: %s", err.Error()))
+	}
+
+	if body.Action == "" || body.Group == "" {
+		return e.JSON(400, errorJSON("Missing parameters"))
+	}
+
+	// No new job while the server is shutting down
+	if stopping.Load() {
+		return e.JSON(503, errorJSON("Server is shutting down"))
+	}
+
+	// Same role as the jobs create rule
+	if err := checkPermission(e, body.Group, 20); err != nil {
+		return err
+	}
+
+	collection, err := app.FindCollectionByNameOrId("jobs")
+	if err != nil {
+		return e.JSON(500, errorJSON("jobs collection not found"))
+	}
+
+	job := core.NewRecord(collection)
+	job.Id = core.GenerateDefaultRandomId()
+	job.Set("action", body.Action)
+	job.Set("group", body.Group)
+	job.Set("priority", body.Priority)
+	job.Set("dependsOn", body.DependsOn)
+	job.Set("idempotencyKey", body.IdempotencyKey)
+	if len(body.Input) > 0 {
+		job.Set("input", body.Input)
+	}
+
+	if err := validateJob(job); err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+	if err := validateJobDependencies(app, job); err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	wait := defaultRunJobWait
+	if body.Timeout > 0 {
+		wait = time.Duration(body.Timeout * float64(time.Second))
+	}
+	wait = min(wait, envDuration("JOB_RUN_MAX_WAIT", maxRunJobWait))
+
+	jobCreateMu.Lock()
+
+	// A job with the same idempotency key is awaited instead of creating a duplicate
+	if existing := findIdempotentJob(app, body.Group, body.IdempotencyKey); existing != nil {
+		log.Info("Duplicate job run request", "id", existing.Id, "idempotencyKey", body.IdempotencyKey)
+		job = existing
+	}
+
+	// Subscribe before saving so that no update is missed
+	wake, unsubscribe := taskEvents.subscribe("jobs/" + job.Id)
+	defer unsubscribe()
+
+	if job.IsNew() {
+		if err := app.Save(job); err != nil {
+			jobCreateMu.Unlock()
+			log.Error("Failed to create job", "err", err)
+			return e.JSON(400, errorJSON("Failed to create job: %s", err.Error()))
+		}
+
+		log.Info("Job run requested", "id", job.Id, "action", body.Action, "wait", wait)
+	}
+
+	jobCreateMu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		if job, err = app.FindRecordById("jobs", job.Id); err != nil {
+			return e.JSON(404, errorJSON("Job not found"))
+		}
+
+		if isJobDone(job) {
+			return e.JSON(200, map[string]any{
+				"id":        job.Id,
+				"status":    job.GetString("status"),
+				"result":    job.Get("result"),
+				"error":     job.GetString("error"),
+				"errorCode": job.GetString("errorCode"),
+			})
+		}
+
+		select {
+		case <-e.Request.Context().Done():
+			return nil
+		case <-timer.C:
+			return e.JSON(202, map[string]any{
+				"id":       job.Id,
+				"status":   job.GetString("status"),
+				"progress": job.GetInt("progress"),
+			})
+		case <-wake:
+		}
+	}
+}
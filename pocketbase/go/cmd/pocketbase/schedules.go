@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // fuseaux horaires embarqués (l'image alpine n'a pas tzdata)
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	maxScheduleHistory = 50             // Nombre d'exécutions conservées dans l'historique
+	maxScheduleLookup  = 366 * 24 * 60  // Recherche de la prochaine exécution sur un an (en minutes)
+	defaultTimezone    = "Europe/Paris" // Fuseau utilisé si le schedule n'en précise pas
+)
+
+// ScheduleRun est une entrée de l'historique d'un schedule
+type ScheduleRun struct {
+	Time  string `json:"time"`
+	Job   string `json:"job,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// parseSchedule valide l'expression cron et le fuseau horaire d'un schedule
+func parseSchedule(record *core.Record) (*cron.Schedule, *time.Location, error) {
+	schedule, err := cron.NewSchedule(record.GetString("cron"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	timezone := record.GetString("timezone")
+	if timezone == "" {
+		timezone = defaultTimezone
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	return schedule, location, nil
+}
+
+// nextScheduleRun cherche la prochaine minute (strictement après after) où le schedule est dû
+func nextScheduleRun(schedule *cron.Schedule, location *time.Location, after time.Time) (time.Time, error) {
+	t := after.In(location).Truncate(time.Minute)
+
+	for i := 0; i < maxScheduleLookup; i++ {
+		t = t.Add(time.Minute)
+		if schedule.IsDue(cron.NewMoment(t)) {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no run within a year")
+}
+
+// newScheduleJob prépare le job d'un schedule, validé comme un job créé par l'API
+// (input conforme au manifest de l'action)
+func newScheduleJob(app core.App, record *core.Record) (*core.Record, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	job := core.NewRecord(collection)
+	job.Set("action", record.GetString("action"))
+	job.Set("input", record.Get("input"))
+	job.Set("group", record.GetString("group"))
+
+	if err := validateJob(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// prepareSchedule valide le schedule et l'input de son action, et calcule sa prochaine exécution
+// (hook de requête create/update)
+func prepareSchedule(e *core.RecordRequestEvent) error {
+	record := e.Record
+
+	schedule, location, err := parseSchedule(record)
+	if err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	if _, err := newScheduleJob(e.App, record); err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	next, err := nextScheduleRun(schedule, location, time.Now())
+	if err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	record.Set("nextRun", next)
+
+	return e.Next()
+}
+
+// runSchedule crée le job d'un schedule arrivé à échéance et planifie l'exécution suivante
+func runSchedule(app *pocketbase.PocketBase, record *core.Record, now time.Time) {
+	logger := app.Logger()
+
+	run := ScheduleRun{Time: now.UTC().Format(time.RFC3339)}
+
+	// le manifest de l'action a pu changer depuis l'enregistrement du schedule
+	job, err := newScheduleJob(app, record)
+	if err == nil {
+		err = app.Save(job)
+	}
+	if err != nil {
+		logger.Error("❌ schedule job creation failed", "schedule", record.Id, "err", err)
+		run.Error = err.Error()
+	} else {
+		logger.Info("⏰ schedule job created", "schedule", record.Id, "job", job.Id)
+		run.Job = job.Id
+		record.Set("lastJob", job.Id)
+	}
+
+	var history []ScheduleRun
+	record.UnmarshalJSONField("history", &history)
+	history = append(history, run)
+	if len(history) > maxScheduleHistory {
+		history = history[len(history)-maxScheduleHistory:]
+	}
+
+	record.Set("history", history)
+	record.Set("lastRun", now)
+
+	schedule, location, err := parseSchedule(record)
+	if err == nil {
+		var next time.Time
+		if next, err = nextScheduleRun(schedule, location, now); err == nil {
+			record.Set("nextRun", next)
+		}
+	}
+	if err != nil {
+		logger.Error("❌ schedule disabled", "schedule", record.Id, "err", err)
+		record.Set("enabled", false)
+		record.Set("nextRun", nil)
+	}
+
+	if err := app.Save(record); err != nil {
+		logger.Error("❌ schedule save failed", "schedule", record.Id, "err", err)
+	}
+}
+
+// runDueSchedules lance tous les schedules actifs dont la prochaine exécution est passée
+func runDueSchedules(app *pocketbase.PocketBase) {
+	now := time.Now()
+
+	schedules, err := app.FindRecordsByFilter(
+		"schedules",
+		"enabled = true && nextRun != '' && nextRun <= {:now}",
+		"nextRun",
+		0,
+		0,
+		map[string]any{"now": now.UTC().Format(types.DefaultDateLayout)},
+	)
+	if err != nil {
+		app.Logger().Error("❌ failed to load due schedules", "err", err)
+		return
+	}
+
+	for _, record := range schedules {
+		runSchedule(app, record, now)
+	}
+}
+
+// bindSchedules déclenche chaque minute les schedules (cron par groupe) arrivés à échéance
+func bindSchedules(app *pocketbase.PocketBase) {
+	app.OnRecordCreateRequest("schedules").BindFunc(prepareSchedule)
+	app.OnRecordUpdateRequest("schedules").BindFunc(prepareSchedule)
+
+	app.Cron().MustAdd("schedules", "* * * * *", func() {
+		runDueSchedules(app)
+	})
+}
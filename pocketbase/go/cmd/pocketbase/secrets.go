@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Les secrets d'un groupe (collection secrets : group, name, value) sont chiffrés en AES-GCM
+// avec la clé SECRETS_KEY (32 caractères). La valeur n'est jamais retournée par l'API :
+// elle est seulement injectée en variable d'environnement <name> dans les jobs dont
+// le manifest la déclare ("secrets": ["ODOO_PASSWORD"]).
+
+var errSecretsDisabled = errors.New("secrets are disabled (SECRETS_KEY must be 32 characters)")
+
+// secretNamePattern est le format des noms de secrets (variables d'environnement, même pattern que le schéma)
+var secretNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// secretsKey retourne la clé de chiffrement des secrets
+func secretsKey() (string, error) {
+	key := envString("SECRETS_KEY", "")
+	if len(key) != 32 {
+		return "", errSecretsDisabled
+	}
+	return key, nil
+}
+
+// encryptSecretRequest chiffre la valeur d'un secret créé ou modifié par l'API.
+// PocketBase ignore les champs cachés envoyés par les non-superusers : la valeur est relue dans le body.
+func encryptSecretRequest(e *core.RecordRequestEvent) error {
+	body := struct {
+		Value string `json:"value" form:"value"`
+	}{}
+	if err := e.BindBody(&body); err != nil {
+		return e.JSON(400, errorJSON("Invalid body"))
+	}
+
+	value := body.Value
+	if value == "" {
+		if e.Record.IsNew() {
+			return e.JSON(400, errorJSON("Missing value"))
+		}
+		return e.Next()
+	}
+
+	key, err := secretsKey()
+	if err != nil {
+		return e.JSON(400, errorJSON("%s", err.Error()))
+	}
+
+	encrypted, err := security.Encrypt([]byte(value), key)
+	if err != nil {
+		return e.JSON(500, errorJSON("Failed to encrypt secret"))
+	}
+	e.Record.Set("value", encrypted)
+
+	return e.Next()
+}
+
+// loadJobSecrets déchiffre les secrets names d'un groupe (variable => valeur).
+// Les secrets absents du groupe sont ignorés : le script décide s'ils sont obligatoires.
+func loadJobSecrets(app *pocketbase.PocketBase, group string, names []string) (map[string]string, error) {
+	secrets := map[string]string{}
+	if len(names) == 0 || group == "" {
+		return secrets, nil
+	}
+
+	values := make([]any, len(names))
+	for i, name := range names {
+		values[i] = name
+	}
+
+	records, err := app.FindAllRecords("secrets", dbx.HashExp{"group": group}, dbx.In("name", values...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets: %w", err)
+	}
+	if len(records) == 0 {
+		return secrets, nil
+	}
+
+	key, err := secretsKey()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		value, err := security.Decrypt(record.GetString("value"), key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s: %w", record.GetString("name"), err)
+		}
+		secrets[record.GetString("name")] = string(value)
+	}
+
+	return secrets, nil
+}
+
+// odooSecretNames associe les champs des anciens identifiants Odoo (content "odoo", champ data) aux secrets
+var odooSecretNames = map[string]string{
+	"url":      "ODOO_URL",
+	"db":       "ODOO_DB",
+	"login":    "ODOO_LOGIN",
+	"password": "ODOO_PASSWORD",
+}
+
+// migrateOdooSecrets copie dans les secrets de chaque groupe ses identifiants Odoo encore stockés
+// dans contents (type "odoo"). Les secrets existants ne sont pas écrasés : la migration est rejouable.
+func migrateOdooSecrets(app *pocketbase.PocketBase) {
+	logger := app.Logger()
+
+	contents, err := app.FindAllRecords("contents", dbx.HashExp{"type": "odoo"})
+	if err != nil || len(contents) == 0 {
+		return
+	}
+
+	key, err := secretsKey()
+	if err != nil {
+		logger.Warn("⚠️ odoo credentials not migrated to secrets", "err", err)
+		return
+	}
+
+	collection, err := app.FindCollectionByNameOrId("secrets")
+	if err != nil {
+		logger.Error("❌ secrets collection not found", "err", err)
+		return
+	}
+
+	migrated := 0
+	for _, content := range contents {
+		group := content.GetString("group")
+
+		data := map[string]any{}
+		if group == "" || content.UnmarshalJSONField("data", &data) != nil {
+			continue
+		}
+
+		for field, name := range odooSecretNames {
+			value, _ := data[field].(string)
+			if value == "" {
+				continue
+			}
+
+			if _, err := app.FindFirstRecordByFilter("secrets", "group = {:group} && name = {:name}", dbx.Params{"group": group, "name": name}); err == nil {
+				continue
+			}
+
+			encrypted, err := security.Encrypt([]byte(value), key)
+			if err != nil {
+				logger.Error("❌ odoo secret encryption failed", "group", group, "name", name, "err", err)
+				continue
+			}
+
+			secret := core.NewRecord(collection)
+			secret.Set("group", group)
+			secret.Set("name", name)
+			secret.Set("value", encrypted)
+			if err := app.Save(secret); err != nil {
+				logger.Error("❌ odoo secret migration failed", "group", group, "name", name, "err", err)
+				continue
+			}
+			migrated++
+		}
+	}
+
+	if migrated > 0 {
+		logger.Info("🔐 odoo credentials migrated to secrets", "secrets", migrated)
+	}
+}
+
+// bindSecrets chiffre les secrets à l'écriture, masque leur valeur dans toutes les réponses
+// et migre au démarrage les anciens identifiants Odoo
+func bindSecrets(app *pocketbase.PocketBase) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		migrateOdooSecrets(app)
+		return se.Next()
+	})
+
+	app.OnRecordCreateRequest("secrets").BindFunc(encryptSecretRequest)
+	app.OnRecordUpdateRequest("secrets").BindFunc(encryptSecretRequest)
+
+	// le champ est caché, mais les superusers voient les champs cachés (rendus visibles dans e.Next)
+	app.OnRecordEnrich("secrets").BindFunc(func(e *core.RecordEnrichEvent) error {
+		err := e.Next()
+		e.Record.Hide("value")
+		return err
+	})
+}
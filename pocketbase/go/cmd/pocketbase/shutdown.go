@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/hook"
+)
+
+const (
+	defaultShutdownGrace = 20 * time.Second // Attente des jobs et transcodes en cours à l'arrêt (SHUTDOWN_GRACE)
+	shutdownCancelWait   = 5 * time.Second  // Attente supplémentaire après l'annulation (en plus de JOB_KILL_GRACE)
+)
+
+// errServerStopping est la cause d'annulation des tâches interrompues par l'arrêt du serveur :
+// elles repassent en "pending" au lieu de "cancelled" ou "failed"
+var errServerStopping = errors.New("server stopping")
+
+var (
+	// stopping est vrai dès le début de l'arrêt : plus aucune tâche ne démarre
+	stopping atomic.Bool
+
+	// tasksCtx est le contexte parent des jobs et transcodes locaux, annulé à la fin du délai de grâce
+	tasksCtx, stopTasks = context.WithCancelCause(context.Background())
+
+	// runningTasks compte les jobs et transcodes locaux en cours
+	runningTasks sync.WaitGroup
+)
+
+// isInterrupted indique si une tâche a été interrompue par l'arrêt du serveur
+func isInterrupted(ctx context.Context) bool {
+	return context.Cause(ctx) == errServerStopping
+}
+
+// waitTasks attend la fin des tâches en cours, au plus timeout. Retourne false si des tâches tournent encore.
+func waitTasks(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		runningTasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// drainTasks arrête proprement les tâches : plus de démarrage, attente de SHUTDOWN_GRACE,
+// puis annulation des tâches restantes qui sont remises en attente (pending) avec leur état sauvegardé
+func drainTasks(app *pocketbase.PocketBase) {
+	logger := app.Logger()
+
+	stopping.Store(true)
+	jobQueue.signal()
+
+	grace := envDuration("SHUTDOWN_GRACE", defaultShutdownGrace)
+	logger.Info("🛑 draining jobs and transcodes", "grace", grace)
+
+	if waitTasks(grace) {
+		logger.Info("✅ jobs and transcodes drained")
+		return
+	}
+
+	logger.Warn("⚠️ grace period over, interrupting jobs and transcodes")
+	stopTasks(errServerStopping)
+
+	if !waitTasks(envDuration("JOB_KILL_GRACE", defaultJobKillGrace) + shutdownCancelWait) {
+		logger.Error("❌ jobs and transcodes still running at shutdown")
+	}
+}
+
+// removeTempFiles supprime les fichiers temporaires laissés par une tâche interrompue
+// (dossier job-<id>-* d'un job, sortie <id>_* d'un transcode)
+func removeTempFiles(pattern string) {
+	paths, _ := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+	for _, path := range paths {
+		os.RemoveAll(path)
+	}
+}
+
+// bindShutdown vide les jobs et transcodes en cours avant l'arrêt de l'application.
+// Le hook passe avant l'arrêt du serveur HTTP de PocketBase (priorité -9999) : pendant le délai
+// de grâce, les workers distants et les clients SSE suivent toujours leurs tâches.
+func bindShutdown(app *pocketbase.PocketBase) {
+	app.OnTerminate().Bind(&hook.Handler[*core.TerminateEvent]{
+		Id: "drainTasks",
+		Func: func(e *core.TerminateEvent) error {
+			drainTasks(app)
+			return e.Next()
+		},
+		Priority: -10000,
+	})
+}
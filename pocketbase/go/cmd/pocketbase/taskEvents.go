@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	taskEventsPing     = 15 * time.Second // Intervalle du ping SSE (et relecture de secours du record)
+	taskEventsMaxLines = 500              // Lignes de log envoyées par relecture
+)
+
+// TaskStatus est l'état d'un job ou d'un transcode envoyé par l'événement SSE "status"
+type TaskStatus struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+	ETA      *int   `json:"eta"` // secondes restantes estimées, null si inconnu
+	Error    string `json:"error,omitempty"`
+}
+
+// TaskLog est une ligne de log envoyée par l'événement SSE "log"
+type TaskLog struct {
+	Seq     *int   `json:"seq,omitempty"` // numéro de ligne (jobs uniquement)
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message"`
+	Time    string `json:"time,omitempty"`
+}
+
+// taskEventBroker signale aux flux SSE les modifications d'un job ou d'un transcode.
+// Les signaux sont fusionnés : le flux relit le record et les nouveaux logs à chaque réveil.
+type taskEventBroker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{} // "<collection>/<id>" => abonnés
+}
+
+var taskEvents = &taskEventBroker{subs: map[string]map[chan struct{}]struct{}{}}
+
+// subscribe abonne un flux aux modifications de key, à désabonner avec la fonction retournée
+func (b *taskEventBroker) subscribe(key string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.subs[key] == nil {
+		b.subs[key] = map[chan struct{}]struct{}{}
+	}
+	b.subs[key][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[key], ch)
+		if len(b.subs[key]) == 0 {
+			delete(b.subs, key)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// notify réveille les flux abonnés à key
+func (b *taskEventBroker) notify(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isTaskDone indique si le statut est final (fin du flux)
+func isTaskDone(status string) bool {
+	switch status {
+	case "finished", "failed", "cancelled", "skipped", "deleted":
+		return true
+	}
+	return false
+}
+
+// taskETA estime le temps restant à partir de la progression et du démarrage
+func taskETA(status string, progress int, started time.Time) *int {
+	if status != "processing" || progress <= 0 || progress >= 100 || started.IsZero() {
+		return nil
+	}
+
+	elapsed := time.Since(started).Seconds()
+	eta := int(elapsed * float64(100-progress) / float64(progress))
+
+	return &eta
+}
+
+// writeTaskEvent écrit un événement SSE
+func writeTaskEvent(e *core.RequestEvent, name string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(e.Response, "event: %s\ndata: %s\n\n", name, raw); err != nil {
+		return err
+	}
+
+	return e.Flush()
+}
+
+// taskEventsHandler diffuse en SSE l'état (status, progress, eta) et les nouveaux logs
+// d'un job ou d'un transcode, jusqu'à la fin de la tâche
+func taskEventsHandler(collection string) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		app := e.App
+		id := e.Request.PathValue("id")
+
+		record, err := app.FindRecordById(collection, id)
+		if err != nil {
+			return e.JSON(404, errorJSON("Not found"))
+		}
+
+		// Same role as the list/view rules
+		if err := checkPermission(e, record.GetString("group"), 10); err != nil {
+			return err
+		}
+
+		// pas de WriteTimeout pour un flux long
+		rc := http.NewResponseController(e.Response)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return e.JSON(500, errorJSON("Failed to initialize SSE connection"))
+		}
+
+		e.Response.Header().Set("Content-Type", "text/event-stream")
+		e.Response.Header().Set("Cache-Control", "no-store")
+		e.Response.Header().Set("X-Accel-Buffering", "no")
+
+		key := collection + "/" + id
+		wake, unsubscribe := taskEvents.subscribe(key)
+		defer unsubscribe()
+
+		ping := time.NewTicker(taskEventsPing)
+		defer ping.Stop()
+
+		lastSeq := -1 // logs des jobs (job_logs)
+		logsSent := 0 // logs des transcodes (champ texte logs)
+		lastStatus := TaskStatus{}
+
+		for {
+			if record, err = app.FindRecordById(collection, id); err != nil {
+				return writeTaskEvent(e, "status", TaskStatus{Id: id, Status: "deleted"})
+			}
+
+			// nouvelles lignes de log
+			var logs []TaskLog
+			if collection == "jobs" {
+				logs, lastSeq = newJobLogs(app, id, lastSeq)
+			} else {
+				text := record.GetString("logs")
+				if len(text) < logsSent {
+					logsSent = 0
+				}
+				for _, line := range strings.Split(text[logsSent:], "\n") {
+					if line != "" {
+						logs = append(logs, TaskLog{Message: line})
+					}
+				}
+				logsSent = len(text)
+			}
+
+			for _, log := range logs {
+				if err := writeTaskEvent(e, "log", log); err != nil {
+					return nil
+				}
+			}
+
+			// logs restants à envoyer avant l'état
+			if collection == "jobs" && len(logs) == taskEventsMaxLines {
+				continue
+			}
+
+			// état (envoyé s'il a changé)
+			started := record.GetDateTime("started").Time()
+
+			status := TaskStatus{
+				Id:       id,
+				Status:   record.GetString("status"),
+				Progress: record.GetInt("progress"),
+				Error:    record.GetString("error"),
+			}
+			status.ETA = taskETA(status.Status, status.Progress, started)
+
+			if status.Status != lastStatus.Status || status.Progress != lastStatus.Progress || status.Error != lastStatus.Error {
+				if err := writeTaskEvent(e, "status", status); err != nil {
+					return nil
+				}
+				lastStatus = status
+			}
+
+			if isTaskDone(status.Status) {
+				return nil
+			}
+
+			select {
+			case <-e.Request.Context().Done():
+				return nil
+			case <-wake:
+			case <-ping.C:
+				if _, err := fmt.Fprint(e.Response, ": ping\n\n"); err != nil {
+					return nil
+				}
+				if err := e.Flush(); err != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// newJobLogs retourne les lignes de job_logs après lastSeq, et le nouveau dernier seq
+func newJobLogs(app core.App, jobId string, lastSeq int) ([]TaskLog, int) {
+	records, err := app.FindRecordsByFilter(
+		"job_logs",
+		"job = {:job} && seq > {:seq}",
+		"seq",
+		taskEventsMaxLines,
+		0,
+		map[string]any{"job": jobId, "seq": lastSeq},
+	)
+	if err != nil {
+		return nil, lastSeq
+	}
+
+	logs := make([]TaskLog, 0, len(records))
+	for _, record := range records {
+		seq := record.GetInt("seq")
+		lastSeq = seq
+		logs = append(logs, TaskLog{
+			Seq:     &seq,
+			Level:   record.GetString("level"),
+			Message: record.GetString("message"),
+			Time:    record.GetString("time"),
+		})
+	}
+
+	return logs, lastSeq
+}
+
+// bindTaskEvents expose les flux SSE des jobs et des transcodes, alimentés par les hooks de records
+func bindTaskEvents(app *pocketbase.PocketBase) {
+	app.OnRecordAfterUpdateSuccess("jobs", "transcodes").BindFunc(func(e *core.RecordEvent) error {
+		taskEvents.notify(e.Record.Collection().Name + "/" + e.Record.Id)
+		return e.Next()
+	})
+
+	app.OnRecordAfterDeleteSuccess("jobs", "transcodes").BindFunc(func(e *core.RecordEvent) error {
+		taskEvents.notify(e.Record.Collection().Name + "/" + e.Record.Id)
+		return e.Next()
+	})
+
+	app.OnRecordAfterCreateSuccess("job_logs").BindFunc(func(e *core.RecordEvent) error {
+		taskEvents.notify("jobs/" + e.Record.GetString("job"))
+		return e.Next()
+	})
+
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		se.Router.GET("/api/jobs/{id}/events", taskEventsHandler("jobs")).
+			Bind(apis.RequireAuth(), apis.SkipSuccessActivityLog())
+		se.Router.GET("/api/transcodes/{id}/events", taskEventsHandler("transcodes")).
+			Bind(apis.RequireAuth(), apis.SkipSuccessActivityLog())
+
+		return se.Next()
+	})
+}
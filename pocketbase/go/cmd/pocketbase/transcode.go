@@ -3,6 +3,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -114,6 +115,20 @@ var supportedFormats = map[string]FormatConfig{
 		Extension: ".jpg",
 		MimeType:  "image/jpeg",
 	},
+	"HLS": {
+		Name:      "HLS",
+		Codec:     "libx264",
+		Extension: ".m3u8",
+		MimeType:  "application/vnd.apple.mpegurl",
+		Manifest:  "master.m3u8", // Échelle de renditions (voir hls.go)
+	},
+	"DASH": {
+		Name:      "DASH",
+		Codec:     "libx264",
+		Extension: ".mpd",
+		MimeType:  "application/dash+xml",
+		Manifest:  "manifest.mpd", // Échelle de renditions en MP4 fragmenté (voir dash.go)
+	},
 }
 
 type TranscodeProfile struct {
@@ -131,6 +146,7 @@ type FormatConfig struct {
 	Codec     string
 	Extension string
 	MimeType  string
+	Manifest  string // Formats segmentés : nom du manifeste, servi avec ses fichiers par /api/medias/{id}/<format>/{file}
 }
 
 // Handler unifié pour le transcodage et la récupération
@@ -142,8 +158,6 @@ func transcodeHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) err
 		formatName := e.Request.PathValue("format")
 		fakeFilename := e.Request.PathValue("fake_name") // Nom souhaité par le client pour le téléchargement
 
-		logger := app.Logger()
-
 		// Vérifier l'authentification
 		authRecord := e.Auth
 		if authRecord == nil {
@@ -169,7 +183,7 @@ func transcodeHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) err
 		}
 
 		// Vérifier le profil
-		profile, profileExists := transcodeProfiles[profileName]
+		_, profileExists := transcodeProfiles[profileName]
 		if !profileExists {
 			availableProfiles := make([]string, 0, len(transcodeProfiles))
 			for k := range transcodeProfiles {
@@ -206,6 +220,22 @@ func transcodeHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) err
 
 			switch status {
 			case "finished":
+				// Format segmenté : pas de fichier unique, le lecteur suit le manifeste
+				if format.Manifest != "" {
+					if isDownload {
+						return e.JSON(http.StatusBadRequest, map[string]string{
+							"error": fmt.Sprintf("Download is not available for %s", formatName),
+						})
+					}
+					return e.JSON(http.StatusOK, map[string]interface{}{
+						"status":       "ready",
+						"playlist_url": packagedURL(mediaId, profileName, format),
+						"profile":      profileName,
+						"format":       formatName,
+						"progress":     100,
+						"created":      transcodeRecord.GetDateTime("created"),
+					})
+				}
 				if isDownload {
 					// Servir le fichier pour téléchargement avec le nom souhaité par le client
 					finalFilename := fakeFilename + format.Extension
@@ -225,12 +255,22 @@ func transcodeHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) err
 						"created":      transcodeRecord.GetDateTime("created"),
 					})
 				}
+			case "pending":
+				// Même réponse que la création d'un transcodage en attente
+				return e.JSON(http.StatusAccepted, map[string]interface{}{
+					"status":       "pending",
+					"progress":     0,
+					"position":     transcodeQueue.position(transcodeRecord.Id),
+					"transcode_id": transcodeRecord.Id,
+					"message":      transcodeWaitingMessage(),
+				})
 			case "processing":
 				progress := transcodeRecord.GetInt("progress")
 				return e.JSON(http.StatusAccepted, map[string]interface{}{
-					"status":   "processing",
-					"progress": progress,
-					"message":  "Transcoding in progress",
+					"status":       "processing",
+					"progress":     progress,
+					"transcode_id": transcodeRecord.Id,
+					"message":      "Transcoding in progress",
 				})
 			case "failed":
 				errorMsg := transcodeRecord.GetString("error")
@@ -250,32 +290,109 @@ func transcodeHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) err
 			})
 		}
 
-		// Créer un nouveau record de transcodage
-		transcodeRecord, err = createTranscodeRecord(app, mediaId, profileName, formatName)
+		// Pas de nouveau transcodage pendant l'arrêt du serveur
+		if stopping.Load() {
+			return e.JSON(http.StatusServiceUnavailable, map[string]string{
+				"error": "Server is shutting down",
+			})
+		}
+
+		// Créer un nouveau record de transcodage en attente : la file le démarre dès qu'un slot
+		// est libre (ou le confie à un worker distant avec TRANSCODE_REMOTE)
+		transcodeRecord, err = createTranscodeRecord(app, mediaId, profileName, formatName, "pending")
 		if err != nil {
 			return e.JSON(http.StatusInternalServerError, map[string]string{
 				"error": "Failed to create transcode record",
 			})
 		}
 
-		// Lancer le transcodage en arrière-plan
-		go func() {
-			if err := performTranscode(app, originalRecord, transcodeRecord, profile, format); err != nil {
-				logger.Error("❌ Erreur transcodage", "err", err, "mediaId", mediaId, "profile", profileName, "format", formatName)
-			} else {
-				logger.Info("✅ Transcodage terminé", "mediaId", mediaId, "profile", profileName, "format", formatName)
-			}
-		}()
+		// déjà démarré si un slot était libre
+		position := transcodeQueue.position(transcodeRecord.Id)
+		if position == 0 {
+			return e.JSON(http.StatusAccepted, map[string]interface{}{
+				"status":       "processing",
+				"progress":     0,
+				"transcode_id": transcodeRecord.Id,
+				"message":      "Transcoding started",
+			})
+		}
 
 		return e.JSON(http.StatusAccepted, map[string]interface{}{
-			"status":       "processing",
+			"status":       "pending",
 			"progress":     0,
+			"position":     position,
 			"transcode_id": transcodeRecord.Id,
-			"message":      "Transcoding started",
+			"message":      transcodeWaitingMessage(),
 		})
 	}
 }
 
+// Message d'attente d'un transcodage en file
+func transcodeWaitingMessage() string {
+	if envBool("TRANSCODE_REMOTE", false) {
+		return "Waiting for a worker"
+	}
+	return "Waiting in queue"
+}
+
+// Exécute un transcodage local (appelé par la file). Interrompu par l'arrêt du serveur,
+// il repasse en attente (pending) et sera relancé au démarrage suivant.
+func runTranscode(app *pocketbase.PocketBase, originalRecord *core.Record, transcodeRecord *core.Record, profile TranscodeProfile, format FormatConfig) {
+	logger := app.Logger()
+
+	err := performTranscode(tasksCtx, app, originalRecord, transcodeRecord, "", profile, format)
+
+	switch {
+	case isInterrupted(tasksCtx):
+		logger.Warn("⏸️ Transcodage interrompu par l'arrêt du serveur", "recordId", transcodeRecord.Id)
+		transcodeRecord.Set("status", "pending")
+		transcodeRecord.Set("progress", 0)
+		transcodeRecord.Set("error", "")
+		transcodeRecord.Set("logs", transcodeRecord.GetString("logs")+"\n=== INTERRUPTED BY SERVER SHUTDOWN ===")
+		if err := app.Save(transcodeRecord); err != nil {
+			logger.Error("❌ Erreur sauvegarde transcodage interrompu", "recordId", transcodeRecord.Id, "err", err)
+		}
+	case err != nil:
+		logger.Error("❌ Erreur transcodage", "err", err, "mediaId", originalRecord.Id, "profile", profile.Name, "format", format.Name)
+	default:
+		logger.Info("✅ Transcodage terminé", "mediaId", originalRecord.Id, "profile", profile.Name, "format", format.Name)
+	}
+}
+
+// Reprise des transcodages locaux au démarrage : les transcodages restés en cours (arrêt brutal)
+// perdent leurs fichiers temporaires et repassent en attente, puis les transcodages en attente
+// sont remis en file (démarrés localement ou, avec TRANSCODE_REMOTE, confiés aux workers)
+func recoverTranscodes(app *pocketbase.PocketBase) {
+	logger := app.Logger()
+
+	orphans, err := app.FindRecordsByFilter("transcodes", "status = 'processing' && worker = ''", "created", 0, 0)
+	if err != nil {
+		logger.Error("❌ Erreur chargement transcodages orphelins", "err", err)
+	}
+
+	for _, record := range orphans {
+		removeTempFiles(record.Id + "_*")
+
+		record.Set("status", "pending")
+		record.Set("progress", 0)
+		if err := app.Save(record); err != nil {
+			logger.Error("❌ Erreur reprise transcodage orphelin", "recordId", record.Id, "err", err)
+		}
+	}
+
+	pending, err := app.FindRecordsByFilter("transcodes", "status = 'pending'", "created", 0, 0)
+	if err != nil {
+		logger.Error("❌ Erreur chargement transcodages en attente", "err", err)
+		return
+	}
+
+	for _, record := range pending {
+		transcodeQueue.push(record)
+	}
+
+	logger.Info("📋 Transcodages repris", "orphans", len(orphans), "pending", len(pending))
+}
+
 // Trouve un enregistrement de transcodage existant
 func findTranscodeRecord(app *pocketbase.PocketBase, mediaId, profile, format string) (*core.Record, error) {
 	records, err := app.FindRecordsByFilter(
@@ -299,7 +416,7 @@ func findTranscodeRecord(app *pocketbase.PocketBase, mediaId, profile, format st
 }
 
 // Crée un nouveau record de transcodage
-func createTranscodeRecord(app *pocketbase.PocketBase, mediaId, profile, format string) (*core.Record, error) {
+func createTranscodeRecord(app *pocketbase.PocketBase, mediaId, profile, format, status string) (*core.Record, error) {
 	transcodeCollection, err := app.FindCollectionByNameOrId("transcodes")
 	if err != nil {
 		return nil, fmt.Errorf("transcodes collection not found: %w", err)
@@ -321,7 +438,7 @@ func createTranscodeRecord(app *pocketbase.PocketBase, mediaId, profile, format
 	newRecord.Set("media", mediaId)
 	newRecord.Set("profile", profile)
 	newRecord.Set("format", format)
-	newRecord.Set("status", "processing")
+	newRecord.Set("status", status)
 	newRecord.Set("progress", 0)
 	newRecord.Set("group", mediaRecord.GetString("group")) // Copier le group du media
 
@@ -390,8 +507,10 @@ func serveTranscodeFile(app *pocketbase.PocketBase, record *core.Record, customF
 	return nil
 }
 
-// Effectue le transcodage complet avec mise à jour des logs et progression
-func performTranscode(app *pocketbase.PocketBase, originalRecord *core.Record, transcodeRecord *core.Record, profile TranscodeProfile, format FormatConfig) error {
+// Effectue le transcodage complet avec mise à jour des logs et progression.
+// sourcePath est le fichier à transcoder, "" pour le fichier du media dans le stockage local.
+// L'annulation de ctx arrête ffmpeg (le fichier temporaire est supprimé).
+func performTranscode(ctx context.Context, app *pocketbase.PocketBase, originalRecord *core.Record, transcodeRecord *core.Record, sourcePath string, profile TranscodeProfile, format FormatConfig) error {
 	logger := app.Logger()
 
 	// Log de démarrage
@@ -410,7 +529,9 @@ func performTranscode(app *pocketbase.PocketBase, originalRecord *core.Record, t
 	updateTranscodeProgress(app, transcodeRecord, 2, fmt.Sprintf("Source file: %s", originalFile))
 
 	// Construire le chemin complet du fichier source
-	sourcePath := filepath.Join(app.DataDir(), "storage", originalRecord.Collection().Id, originalRecord.Id, originalFile)
+	if sourcePath == "" {
+		sourcePath = filepath.Join(app.DataDir(), "storage", originalRecord.Collection().Id, originalRecord.Id, originalFile)
+	}
 	logger.Info("📂 Chemin source construit", "path", sourcePath)
 	updateTranscodeProgress(app, transcodeRecord, 3, fmt.Sprintf("Source path: %s", sourcePath))
 
@@ -426,12 +547,22 @@ func performTranscode(app *pocketbase.PocketBase, originalRecord *core.Record, t
 	// Créer un fichier temporaire pour la sortie
 	tempDir := os.TempDir()
 	outputFile := filepath.Join(tempDir, fmt.Sprintf("%s_%s_%s%s", transcodeRecord.Id, profile.Name, format.Name, format.Extension))
+
+	// Format segmenté : un dossier temporaire reçoit les renditions et le manifeste
+	if format.Manifest != "" {
+		outputFile = filepath.Join(tempDir, fmt.Sprintf("%s_%s_%s", transcodeRecord.Id, profile.Name, format.Name))
+		if err := os.MkdirAll(outputFile, 0o755); err != nil {
+			updateTranscodeError(app, transcodeRecord, fmt.Sprintf("Failed to create temp dir: %v", err))
+			return err
+		}
+	}
+
 	logger.Info("📝 Fichier temporaire créé", "path", outputFile)
 	updateTranscodeProgress(app, transcodeRecord, 5, fmt.Sprintf("Temp output file: %s", outputFile))
 
 	defer func() {
 		logger.Info("🧹 Nettoyage du fichier temporaire", "path", outputFile)
-		os.Remove(outputFile)
+		os.RemoveAll(outputFile)
 	}()
 
 	// 1. Analyser la vidéo avec ffprobe (sauf pour JPEG)
@@ -464,7 +595,7 @@ func performTranscode(app *pocketbase.PocketBase, originalRecord *core.Record, t
 		logger.Info("🖼️ === PHASE 2: EXTRACTION IMAGE ===")
 		updateTranscodeProgress(app, transcodeRecord, 15, "=== PHASE 2: IMAGE EXTRACTION ===")
 
-		if err := extractImage(sourcePath, outputFile, transcodeRecord, app); err != nil {
+		if err := extractImage(ctx, sourcePath, outputFile, transcodeRecord, app); err != nil {
 			logger.Error("❌ Erreur extraction image", "err", err)
 			updateTranscodeError(app, transcodeRecord, fmt.Sprintf("Image extraction error: %v", err))
 			return err
@@ -476,13 +607,21 @@ func performTranscode(app *pocketbase.PocketBase, originalRecord *core.Record, t
 		logger.Info("🎬 === PHASE 2: TRANSCODAGE VIDEO ===")
 		updateTranscodeProgress(app, transcodeRecord, 15, "=== PHASE 2: VIDEO TRANSCODING ===")
 
-		if err := transcodeVideo(sourcePath, outputFile, profile, format, transcodeRecord, totalFrames, videoDuration, app); err != nil {
+		if err := transcodeVideo(ctx, sourcePath, outputFile, profile, format, transcodeRecord, totalFrames, videoDuration, app); err != nil {
 			logger.Error("❌ Erreur transcodage vidéo", "err", err)
 			updateTranscodeError(app, transcodeRecord, fmt.Sprintf("Video transcoding error: %v", err))
 			return err
 		}
 		logger.Info("✅ Transcodage vidéo terminé")
 		updateTranscodeProgress(app, transcodeRecord, 90, "Video transcoding completed")
+
+		if format.Name == "HLS" {
+			if err := writeHLSMaster(outputFile, profile, format.Manifest); err != nil {
+				logger.Error("❌ Erreur playlist maître HLS", "err", err)
+				updateTranscodeError(app, transcodeRecord, fmt.Sprintf("HLS master playlist error: %v", err))
+				return err
+			}
+		}
 	}
 
 	// Vérifier que le fichier de sortie existe
@@ -653,7 +792,7 @@ func analyzeWithFFProbe(inputPath string) (string, int, float64, error) {
 }
 
 // Extrait une image
-func extractImage(inputPath, outputPath string, transcodeRecord *core.Record, app *pocketbase.PocketBase) error {
+func extractImage(ctx context.Context, inputPath, outputPath string, transcodeRecord *core.Record, app *pocketbase.PocketBase) error {
 	fmt.Printf("🖼️ === EXTRACTION IMAGE ===\n")
 	fmt.Printf("📁 Input: %s\n", inputPath)
 	fmt.Printf("📁 Output: %s\n", outputPath)
@@ -675,7 +814,7 @@ func extractImage(inputPath, outputPath string, transcodeRecord *core.Record, ap
 	transcodeRecord.Set("logs", currentLogs+"\n=== IMAGE EXTRACTION COMMAND ===\n"+commandLine+"\n=== EXTRACTION STDERR ===")
 	app.Save(transcodeRecord)
 
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
 	// Capturer stderr
 	stderr, err := cmd.StderrPipe()
@@ -751,9 +890,20 @@ func extractImage(inputPath, outputPath string, transcodeRecord *core.Record, ap
 	return nil
 }
 
-// Transcode la vidéo avec suivi de progression basé sur les frames et la durée
-func transcodeVideo(inputPath, outputPath string, profile TranscodeProfile, format FormatConfig, transcodeRecord *core.Record, totalFrames int, videoDuration float64, app *pocketbase.PocketBase) error {
-	logger := app.Logger()
+// Filtre de mise à l'échelle du profil (bandes noires pour conserver le ratio)
+func scaleFilter(profile TranscodeProfile) string {
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+		profile.Width, profile.Height, profile.Width, profile.Height)
+}
+
+// Construit la commande ffmpeg d'un transcodage vidéo (fichier unique ou échelle segmentée)
+func ffmpegVideoArgs(ctx context.Context, inputPath, outputPath string, profile TranscodeProfile, format FormatConfig) []string {
+	switch format.Name {
+	case "HLS":
+		return hlsArgs(inputPath, outputPath, profile)
+	case "DASH":
+		return dashArgs(ctx, inputPath, outputPath, profile)
+	}
 
 	args := []string{
 		"-i", inputPath,
@@ -767,10 +917,7 @@ func transcodeVideo(inputPath, outputPath string, profile TranscodeProfile, form
 	}
 
 	// Ajouter la résolution
-	args = append(args,
-		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
-			profile.Width, profile.Height, profile.Width, profile.Height),
-	)
+	args = append(args, "-vf", scaleFilter(profile))
 
 	// Ajouter l'audio seulement si ce n'est pas un format image
 	if format.Name != "JPEG" {
@@ -785,6 +932,15 @@ func transcodeVideo(inputPath, outputPath string, profile TranscodeProfile, form
 	// Forcer l'overwrite et configurer la sortie pour le parsing
 	args = append(args, "-y", "-progress", "pipe:2", outputPath)
 
+	return args
+}
+
+// Transcode la vidéo avec suivi de progression basé sur les frames et la durée
+func transcodeVideo(ctx context.Context, inputPath, outputPath string, profile TranscodeProfile, format FormatConfig, transcodeRecord *core.Record, totalFrames int, videoDuration float64, app *pocketbase.PocketBase) error {
+	logger := app.Logger()
+
+	args := ffmpegVideoArgs(ctx, inputPath, outputPath, profile, format)
+
 	commandLine := "ffmpeg " + strings.Join(args, " ")
 	logger.Info("🚀 Commande FFmpeg", "command", commandLine)
 	fmt.Printf("🎬 === COMMANDE FFMPEG ===\n%s\n", commandLine)
@@ -794,7 +950,7 @@ func transcodeVideo(inputPath, outputPath string, profile TranscodeProfile, form
 	transcodeRecord.Set("logs", currentLogs+"\n=== FFMPEG COMMAND ===\n"+commandLine+"\n=== FFMPEG STDERR OUTPUT ===")
 	app.Save(transcodeRecord)
 
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
 	// Capturer stderr pour la progression
 	stderr, err := cmd.StderrPipe()
@@ -988,6 +1144,27 @@ func saveTranscodedFile(app *pocketbase.PocketBase, transcodeRecord *core.Record
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
+	// Format segmenté : tout le dossier (renditions et manifeste) est associé au record
+	if fileInfo.IsDir() {
+		format := supportedFormats[transcodeRecord.GetString("format")]
+		count, err := savePackagedFiles(transcodeRecord, format, filePath)
+		if err != nil {
+			fmt.Printf("❌ Erreur fichiers segmentés: %v\n", err)
+			return fmt.Errorf("failed to add packaged files: %w", err)
+		}
+
+		if err := app.Save(transcodeRecord); err != nil {
+			fmt.Printf("❌ Erreur sauvegarde record: %v\n", err)
+			return fmt.Errorf("failed to save transcoded record: %w", err)
+		}
+
+		currentLogs := transcodeRecord.GetString("logs")
+		transcodeRecord.Set("logs", currentLogs+fmt.Sprintf("\n=== FILES SAVED ===\nDirectory: %s\nFiles: %d\nManifest: %s", filePath, count, format.Manifest))
+		app.Save(transcodeRecord)
+
+		return nil
+	}
+
 	fmt.Printf("📏 Taille du fichier: %d bytes\n", fileInfo.Size())
 
 	// Créer l'objet filesystem pour le fichier
@@ -1063,7 +1240,21 @@ func bindTranscode(app *pocketbase.PocketBase) {
 		se.Router.GET("/api/medias/{id}/transcode/{profile}/{format}/{fake_name}",
 			transcodeHandler(app),
 		)
+		se.Router.GET("/api/medias/{id}/hls/{profile}/{file}", hlsHandler(app))
+		se.Router.GET("/api/medias/{id}/dash/{profile}/{file}", dashHandler(app))
+
+		recoverTranscodes(app)
+		go transcodeQueue.run(app)
 
 		return se.Next()
 	})
+
+	// File des transcodages : tout transcodage passé en attente (création, bail rendu
+	// par un worker, interruption) y entre, les autres en sortent
+	app.OnRecordAfterCreateSuccess("transcodes").BindFunc(queueTranscode)
+	app.OnRecordAfterUpdateSuccess("transcodes").BindFunc(queueTranscode)
+	app.OnRecordAfterDeleteSuccess("transcodes").BindFunc(func(e *core.RecordEvent) error {
+		transcodeQueue.remove(e.Record.Id)
+		return e.Next()
+	})
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Les formats segmentés (HLS, DASH) produisent une échelle de renditions (SD jusqu'au profil demandé),
+// découpées en segments de packagedSegmentSeconds, et un manifeste qui les liste.
+// Le manifeste est stocké dans le champ output, les autres fichiers dans le champ files,
+// sous leur nom d'origine : le manifeste et les playlists les référencent par chemin relatif.
+
+const packagedSegmentSeconds = 6 // Durée cible des segments (les keyframes sont alignées sur toutes les renditions)
+
+// transcodeLadderNames est l'ordre croissant des profils de l'échelle
+var transcodeLadderNames = []string{"SD", "HD", "FHD", "UHD"}
+
+// packagedFileNamePattern est le format des fichiers d'un transcodage segmenté (pas de chemin)
+var packagedFileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+\.[a-z0-9]+$`)
+
+// packagedContentTypes sont les types MIME servis pour les fichiers des transcodages segmentés
+var packagedContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+}
+
+// transcodeLadder retourne les profils de l'échelle jusqu'au profil demandé (inclus)
+func transcodeLadder(top TranscodeProfile) []TranscodeProfile {
+	ladder := []TranscodeProfile{}
+	for _, name := range transcodeLadderNames {
+		profile := transcodeProfiles[name]
+		if profile.Height <= top.Height {
+			ladder = append(ladder, profile)
+		}
+	}
+	return ladder
+}
+
+// bitrateKbps convertit un débit ffmpeg ("2500k") en kbit/s
+func bitrateKbps(bitrate string) int {
+	kbps, _ := strconv.Atoi(strings.TrimSuffix(bitrate, "k"))
+	return kbps
+}
+
+// setPackagedFile associe un fichier d'un transcodage segmenté au record en gardant son nom d'origine :
+// le manifeste dans output, les autres fichiers dans files
+func setPackagedFile(record *core.Record, format FormatConfig, file *filesystem.File) error {
+	name := filepath.Base(file.OriginalName)
+	if !packagedFileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid file name %q", file.OriginalName)
+	}
+	file.Name = name
+
+	if name == format.Manifest {
+		record.Set("output", file)
+	} else {
+		record.Set("files+", file)
+	}
+
+	return nil
+}
+
+// savePackagedFiles associe au record tous les fichiers du dossier de sortie, le manifeste en dernier
+func savePackagedFiles(transcodeRecord *core.Record, format FormatConfig, outputDir string) (int, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return 0, err
+	}
+
+	transcodeRecord.Set("files", nil)
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Name() != format.Manifest {
+			names = append(names, entry.Name())
+		}
+	}
+	names = append(names, format.Manifest)
+
+	for _, name := range names {
+		file, err := filesystem.NewFileFromPath(filepath.Join(outputDir, name))
+		if err != nil {
+			return 0, fmt.Errorf("failed to create filesystem: %w", err)
+		}
+		if err := setPackagedFile(transcodeRecord, format, file); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(names), nil
+}
+
+// packagedURL retourne l'URL du manifeste d'un transcodage segmenté (servi par servePackagedFile)
+func packagedURL(mediaId string, profile string, format FormatConfig) string {
+	return fmt.Sprintf("/api/medias/%s/%s/%s/%s", mediaId, strings.ToLower(format.Name), profile, format.Manifest)
+}
+
+// Sert un fichier (playlist ou segment) du dernier transcodage terminé d'un media dans un format segmenté,
+// pour le profil de la route, aux membres du groupe du media
+func servePackagedFile(e *core.RequestEvent, format FormatConfig) error {
+	app := e.App
+
+	if e.Auth == nil {
+		return e.JSON(401, errorJSON("Authentication required"))
+	}
+
+	mediaId := e.Request.PathValue("id")
+	profile := e.Request.PathValue("profile")
+	name := e.Request.PathValue("file")
+
+	media, err := app.FindRecordById("medias", mediaId)
+	if err != nil {
+		return e.JSON(404, errorJSON("Media not found"))
+	}
+
+	// même rôle que la règle de lecture des medias
+	if err := checkPermission(e, media.GetString("group"), 10); err != nil {
+		return err
+	}
+
+	records, err := app.FindRecordsByFilter(
+		"transcodes",
+		"media = {:mediaId} && profile = {:profile} && format = {:format} && status = 'finished'",
+		"-created",
+		1,
+		0,
+		map[string]any{"mediaId": mediaId, "profile": profile, "format": format.Name},
+	)
+	if err != nil || len(records) == 0 {
+		return e.JSON(404, errorJSON("%s %s not available, request /api/medias/%s/transcode/%s/%s/{name} first", format.Name, profile, mediaId, profile, format.Name))
+	}
+	record := records[0]
+
+	if name != record.GetString("output") && !containsString(record.GetStringSlice("files"), name) {
+		return e.JSON(404, errorJSON("File not found"))
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return e.JSON(500, errorJSON("Filesystem error"))
+	}
+	defer fsys.Close()
+
+	// servi en ligne : les lecteurs chargent playlists et segments directement
+	if contentType, ok := packagedContentTypes[filepath.Ext(name)]; ok {
+		e.Response.Header().Set("Content-Type", contentType)
+	}
+	e.Response.Header().Set("Content-Disposition", "inline; filename="+name)
+
+	return fsys.Serve(e.Response, e.Request, record.BaseFilesPath()+"/"+name, name)
+}
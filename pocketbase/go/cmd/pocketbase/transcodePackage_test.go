@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// newPackagedTestApp crée une app de test avec un media du groupe "member"
+// et son transcodage DASH terminé. Retourne l'app, le media et un utilisateur par groupe.
+func newPackagedTestApp(t *testing.T) (*tests.TestApp, *core.Record, map[string]*core.Record) {
+	t.Helper()
+
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// collections réduites aux champs utilisés par servePackagedFile et checkPermission
+	groups := core.NewBaseCollection("groups")
+	groups.Fields.Add(&core.TextField{Name: "name"})
+	if err := app.Save(groups); err != nil {
+		t.Fatal(err)
+	}
+
+	members := core.NewBaseCollection("members")
+	members.Fields.Add(
+		&core.NumberField{Name: "role"},
+		&core.RelationField{Name: "user", CollectionId: users.Id, MaxSelect: 1},
+		&core.RelationField{Name: "group", CollectionId: groups.Id, MaxSelect: 1},
+	)
+	if err := app.Save(members); err != nil {
+		t.Fatal(err)
+	}
+
+	medias := core.NewBaseCollection("medias")
+	medias.Fields.Add(
+		&core.TextField{Name: "name"},
+		&core.RelationField{Name: "group", CollectionId: groups.Id, MaxSelect: 1},
+	)
+	if err := app.Save(medias); err != nil {
+		t.Fatal(err)
+	}
+
+	transcodes := core.NewBaseCollection("transcodes")
+	transcodes.Fields.Add(
+		&core.SelectField{Name: "status", Values: []string{"pending", "processing", "finished", "failed", "deleted"}, MaxSelect: 1},
+		&core.SelectField{Name: "profile", Values: []string{"SD", "HD", "FHD", "UHD"}, MaxSelect: 1},
+		&core.SelectField{Name: "format", Values: []string{"HLS", "DASH"}, MaxSelect: 1},
+		&core.FileField{Name: "output", MaxSelect: 1},
+		&core.FileField{Name: "files", MaxSelect: 5000},
+		&core.RelationField{Name: "media", CollectionId: medias.Id, MaxSelect: 1},
+		&core.RelationField{Name: "group", CollectionId: groups.Id, MaxSelect: 1},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(transcodes); err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(collection string, data map[string]any) *core.Record {
+		c, err := app.FindCollectionByNameOrId(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := core.NewRecord(c)
+		for key, value := range data {
+			record.Set(key, value)
+		}
+		if err := app.Save(record); err != nil {
+			t.Fatalf("failed to save %s: %v", collection, err)
+		}
+		return record
+	}
+
+	accounts := map[string]*core.Record{}
+	for _, name := range []string{"member", "outsider"} {
+		user := save("users", map[string]any{"email": name + "@example.com", "password": "1234567890", "verified": true})
+		group := save("groups", map[string]any{"name": name})
+		save("members", map[string]any{"user": user.Id, "group": group.Id, "role": 10})
+		accounts[name] = user
+	}
+
+	member, _ := app.FindFirstRecordByData("members", "user", accounts["member"].Id)
+	media := save("medias", map[string]any{"name": "video", "group": member.GetString("group")})
+
+	manifest, err := filesystem.NewFileFromBytes([]byte("<MPD/>"), "manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcode := save("transcodes", map[string]any{
+		"media":   media.Id,
+		"group":   media.GetString("group"),
+		"profile": "SD",
+		"format":  "DASH",
+		"status":  "finished",
+	})
+	if err := setPackagedFile(transcode, supportedFormats["DASH"], manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Save(transcode); err != nil {
+		t.Fatal(err)
+	}
+
+	return app, media, accounts
+}
+
+func TestServePackagedFilePermission(t *testing.T) {
+	app, media, users := newPackagedTestApp(t)
+
+	scenarios := []struct {
+		name     string
+		auth     *core.Record
+		media    string
+		expected int
+	}{
+		{"anonymous", nil, media.Id, 401},
+		{"other group", users["outsider"], media.Id, 403},
+		{"unknown media", users["outsider"], "missing", 404},
+		{"member", users["member"], media.Id, 200},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/medias/"+s.media+"/dash/SD/manifest.mpd", nil)
+			req.SetPathValue("id", s.media)
+			req.SetPathValue("profile", "SD")
+			req.SetPathValue("file", "manifest.mpd")
+
+			e := &core.RequestEvent{App: app, Auth: s.auth}
+			e.Request = req
+			e.Response = rec
+
+			servePackagedFile(e, supportedFormats["DASH"])
+
+			if rec.Code != s.expected {
+				t.Fatalf("Expected status %d, got %d (%s)", s.expected, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
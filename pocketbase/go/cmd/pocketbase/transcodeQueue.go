@@ -0,0 +1,236 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const maxParallelTranscodes = 1 // Max de transcodages locaux simultanés (TRANSCODE_MAX_PARALLEL)
+
+// queuedTranscode est l'entrée en mémoire d'un transcodage en attente
+type queuedTranscode struct {
+	id      string
+	group   string
+	created types.DateTime
+}
+
+// TranscodeQueue est la file des transcodages, sur le modèle de JobQueue :
+// la collection transcodes (status = "pending") reste la source de vérité,
+// la file en mémoire est reconstruite au démarrage par recoverTranscodes.
+//
+// Les groupes sont servis à tour de rôle (le groupe servi le moins récemment passe en premier),
+// puis le transcodage le plus ancien. Au plus maxParallel transcodages tournent localement ;
+// avec TRANSCODE_REMOTE, aucun ne démarre localement et les workers distants se servent dans la file.
+type TranscodeQueue struct {
+	mu          sync.Mutex
+	pending     map[string]*queuedTranscode
+	running     map[string]bool
+	served      map[string]uint64 // tour de service le plus récent de chaque groupe
+	turn        uint64
+	maxParallel int
+	wake        chan struct{} // signale l'arrivée d'un transcodage ou la libération d'un slot
+}
+
+var transcodeQueue = newTranscodeQueue(envInt("TRANSCODE_MAX_PARALLEL", maxParallelTranscodes))
+
+func newTranscodeQueue(maxParallel int) *TranscodeQueue {
+	return &TranscodeQueue{
+		pending:     map[string]*queuedTranscode{},
+		running:     map[string]bool{},
+		served:      map[string]uint64{},
+		maxParallel: maxParallel,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// signal réveille l'ordonnanceur
+func (q *TranscodeQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push ajoute un transcodage en attente (sans doublon)
+func (q *TranscodeQueue) push(record *core.Record) {
+	q.mu.Lock()
+	q.pending[record.Id] = &queuedTranscode{
+		id:      record.Id,
+		group:   record.GetString("group"),
+		created: record.GetDateTime("created"),
+	}
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// remove retire un transcodage de la file (supprimé ou terminé avant son démarrage)
+func (q *TranscodeQueue) remove(id string) {
+	q.mu.Lock()
+	delete(q.pending, id)
+	q.mu.Unlock()
+}
+
+// done libère le slot d'un transcodage local terminé
+func (q *TranscodeQueue) done(id string) {
+	q.mu.Lock()
+	delete(q.running, id)
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// before indique si a doit passer avant b selon les tours de service served
+func (q *TranscodeQueue) before(served map[string]uint64, a *queuedTranscode, b *queuedTranscode) bool {
+	if served[a.group] != served[b.group] {
+		return served[a.group] < served[b.group]
+	}
+	return a.created.Before(b.created)
+}
+
+// best choisit le prochain transcodage parmi pending (nil si aucun)
+func (q *TranscodeQueue) best(pending map[string]*queuedTranscode, served map[string]uint64) *queuedTranscode {
+	var best *queuedTranscode
+	for _, item := range pending {
+		if best == nil || q.before(served, item, best) {
+			best = item
+		}
+	}
+	return best
+}
+
+// take retire de la file le prochain transcodage et note le tour de service de son groupe
+func (q *TranscodeQueue) take() *queuedTranscode {
+	best := q.best(q.pending, q.served)
+	if best == nil {
+		return nil
+	}
+
+	delete(q.pending, best.id)
+	q.turn++
+	q.served[best.group] = q.turn
+
+	return best
+}
+
+// next retire de la file le prochain transcodage à démarrer localement et lui réserve un slot
+// (nil si aucun, si tous les slots sont pris, en mode distant ou pendant l'arrêt du serveur)
+func (q *TranscodeQueue) next() *queuedTranscode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if stopping.Load() || envBool("TRANSCODE_REMOTE", false) || len(q.running) >= q.maxParallel {
+		return nil
+	}
+
+	item := q.take()
+	if item != nil {
+		q.running[item.id] = true
+	}
+
+	return item
+}
+
+// lease retire de la file le prochain transcodage pour un worker distant (nil si aucun)
+func (q *TranscodeQueue) lease() *queuedTranscode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if stopping.Load() {
+		return nil
+	}
+
+	return q.take()
+}
+
+// position retourne le rang (1 = prochain) d'un transcodage en attente, 0 s'il n'est pas dans la file.
+// L'ordre de service est simulé tour par tour, les groupes alternant comme à l'exécution.
+func (q *TranscodeQueue) position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.pending[id]; !ok {
+		return 0
+	}
+
+	pending := make(map[string]*queuedTranscode, len(q.pending))
+	for key, item := range q.pending {
+		pending[key] = item
+	}
+	served := make(map[string]uint64, len(q.served))
+	for group, turn := range q.served {
+		served[group] = turn
+	}
+	turn := q.turn
+
+	for rank := 1; ; rank++ {
+		best := q.best(pending, served)
+		if best.id == id {
+			return rank
+		}
+
+		delete(pending, best.id)
+		turn++
+		served[best.group] = turn
+	}
+}
+
+// run démarre les transcodages dès qu'un slot est disponible
+func (q *TranscodeQueue) run(app *pocketbase.PocketBase) {
+	for {
+		item := q.next()
+		if item == nil {
+			<-q.wake
+			continue
+		}
+
+		runningTasks.Add(1)
+		safeGo(func() {
+			defer runningTasks.Done()
+			defer q.done(item.id)
+			runQueuedTranscode(app, item.id)
+		})
+	}
+}
+
+// runQueuedTranscode recharge le transcodage et son media, puis l'exécute s'il est toujours en attente
+func runQueuedTranscode(app *pocketbase.PocketBase, id string) {
+	logger := app.Logger()
+
+	record, err := app.FindRecordById("transcodes", id)
+	if err != nil || record.GetString("status") != "pending" {
+		logger.Debug("transcode skipped", "id", id)
+		return
+	}
+
+	profile, okProfile := transcodeProfiles[record.GetString("profile")]
+	format, okFormat := supportedFormats[record.GetString("format")]
+	originalRecord, err := app.FindRecordById("medias", record.GetString("media"))
+	if err != nil || !okProfile || !okFormat {
+		updateTranscodeError(app, record, "Media, profile or format not found")
+		return
+	}
+
+	record.Set("status", "processing")
+	record.Set("started", time.Now())
+	if err := app.Save(record); err != nil {
+		logger.Error("❌ Erreur démarrage transcodage", "recordId", id, "err", err)
+		return
+	}
+
+	runTranscode(app, originalRecord, record, profile, format)
+}
+
+// queueTranscode met en file un transcodage passé en attente (création, bail rendu par un worker)
+func queueTranscode(e *core.RecordEvent) error {
+	if e.Record.GetString("status") == "pending" {
+		transcodeQueue.push(e.Record)
+	} else {
+		transcodeQueue.remove(e.Record.Id)
+	}
+	return e.Next()
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultWorkerPoll      = 5 * time.Second  // Attente entre deux demandes de bail sans tâche (WORKER_POLL)
+	defaultWorkerHeartbeat = 10 * time.Second // Intervalle des heartbeats (WORKER_HEARTBEAT, inférieur à WORKER_LEASE)
+	workerRequestTimeout   = 30 * time.Second // Timeout des requêtes JSON vers le serveur
+	workerFinalAttempts    = 5                // Tentatives d'envoi de l'état final
+)
+
+var (
+	// errWorkerStopped est la cause d'arrêt des tâches à l'arrêt du worker (le bail est rendu)
+	errWorkerStopped = errors.New("worker stopped")
+	// errWorkerLeaseLost est la cause d'arrêt d'une tâche dont le serveur a repris le bail
+	errWorkerLeaseLost = errors.New("lease lost")
+)
+
+// workerClient appelle les routes /api/workers du serveur
+type workerClient struct {
+	server string
+	token  string
+	id     string
+	http   *http.Client
+}
+
+// request envoie une requête authentifiée au serveur
+func (c *workerClient) request(ctx context.Context, method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set(workerHeader, c.id)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return c.http.Do(req)
+}
+
+// postJSON envoie in en JSON et décode la réponse dans out, retourne le code HTTP
+func (c *workerClient) postJSON(ctx context.Context, path string, in any, out any) (int, error) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, workerRequestTimeout)
+	defer cancel()
+
+	res, err := c.request(ctx, http.MethodPost, path, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return res.StatusCode, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	if out != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, err
+		}
+	}
+
+	return res.StatusCode, nil
+}
+
+// download enregistre dans path un fichier d'entrée d'une tâche
+func (c *workerClient) download(ctx context.Context, collection string, id string, name string, path string) error {
+	res, err := c.request(ctx, http.MethodGet, fmt.Sprintf("/api/workers/files/%s/%s/%s", collection, id, url.PathEscape(name)), "", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", name, res.Status)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, res.Body)
+	return err
+}
+
+// upload envoie un fichier produit par une tâche (sans le charger en mémoire) et décode la réponse dans out
+func (c *workerClient) upload(ctx context.Context, lease *workerLease, file *filesystem.File, media bool, out any) error {
+	reader, err := file.Reader.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		err := form.WriteField("media", fmt.Sprint(media))
+		if err == nil {
+			var part io.Writer
+			if part, err = form.CreateFormFile("file", file.OriginalName); err == nil {
+				_, err = io.Copy(part, reader)
+			}
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	res, err := c.request(ctx, http.MethodPost, fmt.Sprintf("/api/workers/%s/%s/files", lease.Collection, lease.Id), form.FormDataContentType(), pr)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("upload %s: %s %s", file.OriginalName, res.Status, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// workerLease est un bail reçu du serveur (WorkerLease côté serveur, records sérialisés)
+type workerLease struct {
+	Collection  string            `json:"collection"`
+	Id          string            `json:"id"`
+	Record      map[string]any    `json:"record"`
+	Schema      json.RawMessage   `json:"schema"`
+	Secrets     map[string]string `json:"secrets"`
+	Media       map[string]any    `json:"media"`
+	MediaSchema json.RawMessage   `json:"mediaSchema"`
+}
+
+// leaseRecord reconstruit un record (hors base) à partir de sa collection et de ses données
+func leaseRecord(schema json.RawMessage, data map[string]any) (*core.Record, error) {
+	collection := &core.Collection{}
+	if err := json.Unmarshal(schema, collection); err != nil {
+		return nil, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Load(data)
+	record.MarkAsNotNew()
+
+	return record, nil
+}
+
+// workerTask est l'état d'une tâche en cours, à remonter au serveur par heartbeat
+type workerTask struct {
+	client *workerClient
+	lease  *workerLease
+
+	mu       sync.Mutex
+	report   WorkerReport
+	logsSent int // transcodes : longueur du champ logs déjà envoyée
+}
+
+// take retourne l'état à envoyer et le réinitialise
+func (t *workerTask) take() WorkerReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := t.report
+	t.report = WorkerReport{}
+
+	return report
+}
+
+// log ajoute une ligne de log (jobs)
+func (t *workerTask) log(level string, args ...any) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = fmt.Sprint(arg)
+	}
+
+	t.mu.Lock()
+	t.report.Logs = append(t.report.Logs, TaskLog{
+		Level:   level,
+		Message: strings.Join(parts, "\t"),
+		Time:    time.Now().UTC().Format(time.RFC3339),
+	})
+	t.mu.Unlock()
+}
+
+// update relève la progression et le résultat (jobs)
+func (t *workerTask) update(key string, val any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch key {
+	case "progress":
+		if progress, ok := val.(int); ok {
+			t.report.Progress = &progress
+		}
+	case "result":
+		t.report.Result, _ = json.Marshal(val)
+	}
+}
+
+// send envoie l'état au serveur, retourne si l'annulation est demandée
+func (t *workerTask) send(ctx context.Context, report WorkerReport) (bool, error) {
+	res := struct {
+		Cancel bool `json:"cancel"`
+	}{}
+
+	status, err := t.client.postJSON(ctx, fmt.Sprintf("/api/workers/%s/%s/heartbeat", t.lease.Collection, t.lease.Id), report, &res)
+	if status == http.StatusConflict {
+		return false, errWorkerLeaseLost
+	}
+
+	return res.Cancel, err
+}
+
+// heartbeat envoie régulièrement l'état de la tâche jusqu'à l'annulation de ctx.
+// Une annulation demandée par le serveur ou la perte du bail arrête la tâche (stop).
+func (t *workerTask) heartbeat(ctx context.Context, stop context.CancelCauseFunc, log func(msg string, args ...any)) {
+	ticker := time.NewTicker(envDuration("WORKER_HEARTBEAT", defaultWorkerHeartbeat))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report := t.take()
+
+		cancel, err := t.send(ctx, report)
+		switch {
+		case errors.Is(err, errWorkerLeaseLost):
+			log("⚠️ lease lost", "id", t.lease.Id)
+			stop(errWorkerLeaseLost)
+			return
+		case err != nil:
+			// l'état non envoyé repart au prochain heartbeat
+			log("⚠️ heartbeat failed", "id", t.lease.Id, "err", err)
+			t.mu.Lock()
+			t.report.Logs = append(report.Logs, t.report.Logs...)
+			t.report.LogsText = report.LogsText + t.report.LogsText
+			if t.report.Progress == nil {
+				t.report.Progress = report.Progress
+			}
+			if t.report.Result == nil {
+				t.report.Result = report.Result
+			}
+			t.mu.Unlock()
+		case cancel:
+			log("🛑 task cancelled by server", "id", t.lease.Id)
+			stop(context.Canceled)
+			return
+		}
+	}
+}
+
+// finish envoie l'état final (ou rend le bail), avec quelques tentatives
+func (t *workerTask) finish(final WorkerReport, log func(msg string, args ...any)) {
+	pending := t.take()
+	final.Logs = append(pending.Logs, final.Logs...)
+	final.LogsText = pending.LogsText + final.LogsText
+	if final.Progress == nil {
+		final.Progress = pending.Progress
+	}
+	if final.Result == nil {
+		final.Result = pending.Result
+	}
+
+	for attempt := 1; attempt <= workerFinalAttempts; attempt++ {
+		_, err := t.send(context.Background(), final)
+		if err == nil || errors.Is(err, errWorkerLeaseLost) {
+			return
+		}
+
+		log("⚠️ final report failed", "id", t.lease.Id, "attempt", attempt, "err", err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// runWorkerJob exécute un job avec le pipeline local (startJob sans record) et remonte son état
+func runWorkerJob(ctx context.Context, app *pocketbase.PocketBase, task *workerTask) {
+	logger := app.Logger()
+	lease := task.lease
+
+	job, err := leaseRecord(lease.Schema, lease.Record)
+	if err != nil {
+		task.finish(WorkerReport{Status: "failed", Error: "Invalid lease: " + err.Error()}, logger.Error)
+		return
+	}
+
+	// les résultats des dépendances sont déjà dans l'input
+	job.Set("dependsOn", nil)
+
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	go task.heartbeat(runCtx, stop, logger.Warn)
+
+	// les secrets viennent du serveur (la base locale n'en a pas)
+	secrets := lease.Secrets
+	if secrets == nil {
+		secrets = map[string]string{}
+	}
+
+	startJob(runCtx, app, job, JobRunOptions{
+		NoRecord: true,
+		Secrets:  secrets,
+		OnLog:    task.log,
+		OnUpdate: task.update,
+		OnFile: func(file *filesystem.File, media bool) (*core.Record, error) {
+			res := map[string]any{}
+			if err := task.client.upload(ctx, lease, file, media, &res); err != nil {
+				return nil, err
+			}
+			if !media {
+				return nil, nil
+			}
+
+			record := core.NewRecord(core.NewBaseCollection("medias"))
+			record.Load(res)
+			return record, nil
+		},
+		FetchFile: func(name string, path string) error {
+			return task.client.download(runCtx, "jobs", lease.Id, name, path)
+		},
+	})
+
+	switch context.Cause(runCtx) {
+	case errWorkerLeaseLost:
+		return
+	case errWorkerStopped:
+		task.finish(WorkerReport{Status: "pending"}, logger.Warn)
+		return
+	}
+
+	progress := job.GetInt("progress")
+	peakMemory := int64(job.GetInt("peakMemory"))
+	cpuTime := int64(job.GetInt("cpuTime"))
+
+	task.finish(WorkerReport{
+		Status:     job.GetString("status"),
+		Progress:   &progress,
+		Error:      job.GetString("error"),
+		ErrorCode:  job.GetString("errorCode"),
+		PeakMemory: &peakMemory,
+		CPUTime:    &cpuTime,
+	}, logger.Warn)
+}
+
+// workerTranscodes sont les transcodes en cours sur ce worker (id => *workerTask) :
+// leurs sauvegardes sont interceptées (bindWorkerTranscodeHook) et remontées au serveur
+var workerTranscodes sync.Map
+
+// save remplace la sauvegarde locale d'un transcode : les fichiers de sortie sont envoyés au serveur
+// (fichiers d'un format segmenté puis manifeste), la progression et la suite des logs partent au prochain heartbeat
+func (t *workerTask) save(record *core.Record) error {
+	if files := record.GetUnsavedFiles("files"); len(files) > 0 {
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			res := struct {
+				Name string `json:"name"`
+			}{}
+			if err := t.client.upload(context.Background(), t.lease, file, false, &res); err != nil {
+				return err
+			}
+			names = append(names, res.Name)
+		}
+		record.Set("files", names)
+	}
+
+	if files := record.GetUnsavedFiles("output"); len(files) > 0 {
+		res := struct {
+			Name string `json:"name"`
+		}{}
+		if err := t.client.upload(context.Background(), t.lease, files[0], false, &res); err != nil {
+			return err
+		}
+		record.Set("output", res.Name)
+	}
+
+	progress := record.GetInt("progress")
+	logs := record.GetString("logs")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.report.Progress = &progress
+	if len(logs) > t.logsSent {
+		t.report.LogsText += logs[t.logsSent:]
+		t.logsSent = len(logs)
+	}
+
+	return nil
+}
+
+// runWorkerTranscode exécute un transcode avec performTranscode : le fichier source est
+// téléchargé là où performTranscode le cherche (stockage local du worker), puis supprimé
+func runWorkerTranscode(ctx context.Context, app *pocketbase.PocketBase, task *workerTask) {
+	logger := app.Logger()
+	lease := task.lease
+
+	transcode, err := leaseRecord(lease.Schema, lease.Record)
+	if err == nil {
+		var media *core.Record
+		if media, err = leaseRecord(lease.MediaSchema, lease.Media); err == nil {
+			err = runLeasedTranscode(ctx, app, task, transcode, media)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errWorkerLeaseLost):
+		return
+	case errors.Is(err, errWorkerStopped):
+		task.finish(WorkerReport{Status: "pending"}, logger.Warn)
+		return
+	}
+
+	status := transcode.GetString("status")
+	message := transcode.GetString("error")
+	if err != nil && status != "failed" {
+		status, message = "failed", err.Error()
+	}
+
+	progress := transcode.GetInt("progress")
+	task.finish(WorkerReport{Status: status, Progress: &progress, Error: message}, logger.Warn)
+}
+
+// runLeasedTranscode prépare le fichier source puis lance performTranscode
+func runLeasedTranscode(ctx context.Context, app *pocketbase.PocketBase, task *workerTask, transcode *core.Record, media *core.Record) error {
+	profile, ok := transcodeProfiles[transcode.GetString("profile")]
+	if !ok {
+		return fmt.Errorf("unknown profile %s", transcode.GetString("profile"))
+	}
+	format, ok := supportedFormats[transcode.GetString("format")]
+	if !ok {
+		return fmt.Errorf("unknown format %s", transcode.GetString("format"))
+	}
+
+	// source téléchargée dans un dossier temporaire, jamais dans le stockage local
+	name := media.GetString("file")
+	dir, err := os.MkdirTemp("", "worker-transcode-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	sourcePath := filepath.Join(dir, name)
+	if err := task.client.download(ctx, "medias", media.Id, name, sourcePath); err != nil {
+		return err
+	}
+
+	task.logsSent = len(transcode.GetString("logs"))
+	workerTranscodes.Store(transcode.Id, task)
+	defer workerTranscodes.Delete(transcode.Id)
+
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	go task.heartbeat(runCtx, stop, app.Logger().Warn)
+
+	performTranscode(runCtx, app, media, transcode, sourcePath, profile, format)
+
+	switch cause := context.Cause(runCtx); cause {
+	case errWorkerLeaseLost, errWorkerStopped:
+		return cause
+	}
+
+	return nil
+}
+
+// bindWorkerTranscodeHook intercepte les sauvegardes des transcodes exécutés par le worker
+// (leur record n'existe pas dans la base locale)
+func bindWorkerTranscodeHook(app *pocketbase.PocketBase) {
+	app.OnRecordUpdate("transcodes").BindFunc(func(e *core.RecordEvent) error {
+		task, ok := workerTranscodes.Load(e.Record.Id)
+		if !ok {
+			return e.Next()
+		}
+		return task.(*workerTask).save(e.Record)
+	})
+}
+
+// defaultWorkerActions retourne les actions dont le script est présent dans jobs/
+func defaultWorkerActions() []string {
+	scripts, _ := filepath.Glob(filepath.Join("jobs", "*.ts"))
+
+	actions := make([]string, 0, len(scripts))
+	for _, script := range scripts {
+		actions = append(actions, strings.TrimSuffix(filepath.Base(script), ".ts"))
+	}
+
+	return actions
+}
+
+// workerCommand démarre un worker distant : il prend des jobs et des transcodes par bail
+// sur le serveur, les exécute localement et remonte progression, logs, résultats et fichiers
+func workerCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var server, token, actions string
+	var concurrency int
+	var transcodes bool
+
+	command := &cobra.Command{
+		Use:          "worker",
+		Example:      "worker --server https://pb.example.com --token TOKEN --transcodes",
+		Short:        "Runs jobs and transcodes leased from a remote server",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			if server == "" || token == "" {
+				return errors.New("missing --server or --token")
+			}
+
+			hostname, _ := os.Hostname()
+			client := &workerClient{
+				server: strings.TrimSuffix(server, "/"),
+				token:  token,
+				id:     hostname + "-" + security.RandomString(6),
+				http:   &http.Client{},
+			}
+
+			body := workerLeaseBody{Transcodes: transcodes}
+			if actions != "" {
+				body.Actions = strings.Split(actions, ",")
+			} else {
+				body.Actions = defaultWorkerActions()
+			}
+
+			logger := app.Logger()
+			logger.Info("👷 worker started", "id", client.id, "server", client.server, "actions", body.Actions, "transcodes", transcodes)
+			fmt.Fprintf(os.Stderr, "👷 worker %s: actions %v, transcodes %v\n", client.id, body.Actions, transcodes)
+
+			bindWorkerTranscodeHook(app)
+
+			// arrêt : les tâches en cours sont interrompues et leurs baux rendus
+			ctx, stop := context.WithCancelCause(context.Background())
+			var running sync.WaitGroup
+			done := make(chan struct{})
+			defer close(done)
+
+			app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+				stop(errWorkerStopped)
+				<-done
+				return e.Next()
+			})
+
+			slots := make(chan struct{}, max(concurrency, 1))
+			poll := envDuration("WORKER_POLL", defaultWorkerPoll)
+
+			for ctx.Err() == nil {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					continue
+				}
+
+				lease := &workerLease{}
+				status, err := client.postJSON(ctx, "/api/workers/lease", body, lease)
+				if err != nil || status == http.StatusNoContent {
+					<-slots
+					if err != nil && ctx.Err() == nil {
+						logger.Warn("⚠️ lease request failed", "err", err)
+					}
+					select {
+					case <-time.After(poll):
+					case <-ctx.Done():
+					}
+					continue
+				}
+
+				logger.Info("📥 task leased", "collection", lease.Collection, "id", lease.Id)
+				fmt.Fprintf(os.Stderr, "📥 %s %s\n", lease.Collection, lease.Id)
+
+				task := &workerTask{client: client, lease: lease}
+
+				running.Add(1)
+				safeGo(func() {
+					defer running.Done()
+					defer func() { <-slots }()
+
+					if lease.Collection == "transcodes" {
+						runWorkerTranscode(ctx, app, task)
+					} else {
+						runWorkerJob(ctx, app, task)
+					}
+
+					fmt.Fprintf(os.Stderr, "✅ %s %s done\n", lease.Collection, lease.Id)
+				})
+			}
+
+			running.Wait()
+
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&server, "server", envString("WORKER_SERVER", ""), "URL of the server (WORKER_SERVER)")
+	command.Flags().StringVar(&token, "token", envString("WORKER_TOKEN", ""), "worker token of the server (WORKER_TOKEN)")
+	command.Flags().StringVar(&actions, "actions", "", "comma separated job actions (default: scripts in jobs/)")
+	command.Flags().IntVar(&concurrency, "concurrency", 1, "max tasks run at the same time")
+	command.Flags().BoolVar(&transcodes, "transcodes", false, "also run transcodes")
+
+	return command
+}
+
+// bindWorkerCommand ajoute la commande worker à la CLI PocketBase
+func bindWorkerCommand(app *pocketbase.PocketBase) {
+	app.RootCmd.AddCommand(workerCommand(app))
+}
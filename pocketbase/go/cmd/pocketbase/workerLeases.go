@@ -0,0 +1,483 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	defaultWorkerLease     = time.Minute // Durée d'un bail sans heartbeat (WORKER_LEASE)
+	defaultWorkerMaxUpload = 4 << 30     // Taille maximale d'un fichier envoyé par un worker (WORKER_MAX_UPLOAD)
+
+	workerHeader = "X-Worker-Id" // identifiant du worker, envoyé avec le token à chaque requête
+)
+
+// errLeaseLost est retourné une fois la réponse 409 écrite : le bail n'appartient plus au worker
+var errLeaseLost = errors.New("lease lost")
+
+// WorkerLease est une tâche (job ou transcode) confiée à un worker distant.
+// Le worker n'a pas accès à la base : le record et le schéma de sa collection lui sont envoyés.
+type WorkerLease struct {
+	Collection  string            `json:"collection"` // jobs ou transcodes
+	Id          string            `json:"id"`
+	Expires     string            `json:"expires"`
+	Record      *core.Record      `json:"record"`
+	Schema      *core.Collection  `json:"schema"`
+	Secrets     map[string]string `json:"secrets,omitempty"`     // secrets déchiffrés déclarés par l'action du job
+	Media       *core.Record      `json:"media,omitempty"`       // media source d'un transcode
+	MediaSchema *core.Collection  `json:"mediaSchema,omitempty"` // schéma de la collection medias
+}
+
+// WorkerReport est l'état remonté par un worker : à chaque heartbeat (progression, logs),
+// puis avec le statut final. Le statut "pending" rend le bail (arrêt du worker).
+type WorkerReport struct {
+	Status     string          `json:"status,omitempty"`
+	Progress   *int            `json:"progress,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	ErrorCode  string          `json:"errorCode,omitempty"`
+	PeakMemory *int64          `json:"peakMemory,omitempty"`
+	CPUTime    *int64          `json:"cpuTime,omitempty"`
+	Logs       []TaskLog       `json:"logs,omitempty"`     // nouvelles lignes de log (jobs)
+	LogsText   string          `json:"logsText,omitempty"` // suite du champ logs (transcodes)
+}
+
+// workerLeaseBody est la demande de bail d'un worker
+type workerLeaseBody struct {
+	Actions    []string `json:"actions"`    // actions de job que le worker sait exécuter
+	Transcodes bool     `json:"transcodes"` // le worker accepte les transcodes
+}
+
+// requireWorker vérifie le token des workers (WORKER_TOKEN, routes désactivées s'il est absent)
+func requireWorker(e *core.RequestEvent) error {
+	token := envString("WORKER_TOKEN", "")
+	if token == "" {
+		return e.JSON(404, errorJSON("Workers are disabled"))
+	}
+
+	auth := strings.TrimPrefix(e.Request.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+		return e.JSON(401, errorJSON("Invalid worker token"))
+	}
+
+	if e.Request.Header.Get(workerHeader) == "" {
+		return e.JSON(400, errorJSON("Missing %s header", workerHeader))
+	}
+
+	return e.Next()
+}
+
+// workerLeaseExpires retourne l'échéance d'un bail accordé ou prolongé maintenant
+func workerLeaseExpires() time.Time {
+	return time.Now().Add(envDuration("WORKER_LEASE", defaultWorkerLease))
+}
+
+// leaseJob confie au worker le prochain job de la file parmi ses actions (nil si aucun)
+func leaseJob(app *pocketbase.PocketBase, worker string, actions []string) (*WorkerLease, error) {
+	for {
+		item := jobQueue.lease(actions)
+		if item == nil {
+			return nil, nil
+		}
+
+		job, err := app.FindRecordById("jobs", item.id)
+		if err != nil || job.GetString("status") != "pending" {
+			jobQueue.done(item.id)
+			continue
+		}
+
+		// le worker n'a accès ni aux dépendances ni aux secrets : leurs résultats sont ajoutés
+		// à l'input et les secrets de l'action envoyés avec le bail
+		secrets, err := loadJobSecrets(app, job.GetString("group"), getJobAction(job.GetString("action")).Secrets)
+		if err == nil {
+			err = setDependencyResults(app, job)
+		}
+		if err != nil {
+			jobQueue.done(item.id)
+			job.Set("status", "failed")
+			job.Set("error", err.Error())
+			app.Save(job)
+			continue
+		}
+
+		expires := workerLeaseExpires()
+
+		job.Set("status", "processing")
+		job.Set("progress", 1)
+		job.Set("started", time.Now())
+		job.Set("outputs", nil) // fichiers produits par une tentative précédente
+		job.Set("worker", worker)
+		job.Set("leaseExpires", expires)
+
+		if err := app.Save(job); err != nil {
+			jobQueue.done(item.id)
+			return nil, err
+		}
+
+		app.Logger().Info("📤 job leased", "id", job.Id, "worker", worker, "action", job.GetString("action"))
+
+		return &WorkerLease{
+			Collection: "jobs",
+			Id:         job.Id,
+			Expires:    expires.UTC().Format(time.RFC3339),
+			Record:     job,
+			Schema:     job.Collection(),
+			Secrets:    secrets,
+		}, nil
+	}
+}
+
+// leaseTranscode confie au worker le prochain transcode de la file (nil si aucun)
+func leaseTranscode(app *pocketbase.PocketBase, worker string) (*WorkerLease, error) {
+	for {
+		item := transcodeQueue.lease()
+		if item == nil {
+			return nil, nil
+		}
+
+		transcode, err := app.FindRecordById("transcodes", item.id)
+		if err != nil || transcode.GetString("status") != "pending" {
+			continue
+		}
+
+		media, err := app.FindRecordById("medias", transcode.GetString("media"))
+		if err != nil {
+			updateTranscodeError(app, transcode, "Media not found")
+			continue
+		}
+
+		expires := workerLeaseExpires()
+
+		transcode.Set("status", "processing")
+		transcode.Set("progress", 0)
+		transcode.Set("started", time.Now())
+		transcode.Set("worker", worker)
+		transcode.Set("leaseExpires", expires)
+
+		if err := app.Save(transcode); err != nil {
+			return nil, err
+		}
+
+		app.Logger().Info("📤 transcode leased", "id", transcode.Id, "worker", worker)
+
+		return &WorkerLease{
+			Collection:  "transcodes",
+			Id:          transcode.Id,
+			Expires:     expires.UTC().Format(time.RFC3339),
+			Record:      transcode,
+			Schema:      transcode.Collection(),
+			Media:       media,
+			MediaSchema: media.Collection(),
+		}, nil
+	}
+}
+
+// leaseWorkerTask attribue un job ou un transcode au worker : 200 avec le bail, 204 si rien à faire
+func leaseWorkerTask(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		worker := e.Request.Header.Get(workerHeader)
+
+		body := workerLeaseBody{}
+		if err := e.BindBody(&body); err != nil {
+			return e.JSON(400, errorJSON("Invalid body: %s", err.Error()))
+		}
+
+		if len(body.Actions) > 0 {
+			lease, err := leaseJob(app, worker, body.Actions)
+			if err != nil {
+				app.Logger().Error("❌ job lease failed", "worker", worker, "err", err)
+				return e.JSON(500, errorJSON("Failed to lease job"))
+			}
+			if lease != nil {
+				return e.JSON(200, lease)
+			}
+		}
+
+		if body.Transcodes {
+			lease, err := leaseTranscode(app, worker)
+			if err != nil {
+				app.Logger().Error("❌ transcode lease failed", "worker", worker, "err", err)
+				return e.JSON(500, errorJSON("Failed to lease transcode"))
+			}
+			if lease != nil {
+				return e.JSON(200, lease)
+			}
+		}
+
+		return e.NoContent(204)
+	}
+}
+
+// leasedRecord charge le job ou le transcode d'un bail et vérifie qu'il appartient toujours au worker
+func leasedRecord(e *core.RequestEvent) (*core.Record, error) {
+	collection := e.Request.PathValue("collection")
+	if collection != "jobs" && collection != "transcodes" {
+		return nil, denyPermission(e, 404, errorJSON("Unknown collection %s", collection))
+	}
+
+	record, err := e.App.FindRecordById(collection, e.Request.PathValue("id"))
+	if err != nil {
+		return nil, denyPermission(e, 404, errorJSON("Not found"))
+	}
+
+	if record.GetString("status") != "processing" || record.GetString("worker") != e.Request.Header.Get(workerHeader) {
+		if err := e.JSON(409, errorJSON("Lease lost")); err != nil {
+			return nil, err
+		}
+		return nil, errLeaseLost
+	}
+
+	return record, nil
+}
+
+// releaseLease remet en attente la tâche d'un bail expiré ou rendu par son worker
+// (un job dont l'annulation a été demandée est annulé)
+func releaseLease(app *pocketbase.PocketBase, record *core.Record, reason string) {
+	app.Logger().Warn("⌛ worker lease released", "collection", record.Collection().Name, "id", record.Id, "worker", record.GetString("worker"), "reason", reason)
+
+	record.Set("status", "pending")
+	record.Set("progress", 0)
+	record.Set("worker", "")
+	record.Set("leaseExpires", nil)
+
+	if record.Collection().Name == "jobs" {
+		if jobQueue.isCancelled(record.Id) {
+			record.Set("status", "cancelled")
+			record.Set("error", "Cancelled")
+		}
+		jobQueue.done(record.Id)
+	}
+
+	if err := app.Save(record); err != nil {
+		app.Logger().Error("❌ failed to release lease", "id", record.Id, "err", err)
+	}
+}
+
+// reportWorkerTask enregistre l'état remonté par le worker et prolonge son bail.
+// La réponse indique si l'annulation du job a été demandée.
+func reportWorkerTask(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+
+		record, err := leasedRecord(e)
+		if err != nil {
+			return err
+		}
+
+		report := WorkerReport{}
+		if err := e.BindBody(&report); err != nil {
+			return e.JSON(400, errorJSON("Invalid body: %s", err.Error()))
+		}
+
+		isJob := record.Collection().Name == "jobs"
+
+		if report.Status == "pending" {
+			releaseLease(app, record, "released by worker")
+			return e.JSON(200, map[string]any{"cancel": false})
+		}
+
+		if report.Progress != nil {
+			record.Set("progress", *report.Progress)
+		}
+		if len(report.Result) > 0 {
+			record.Set("result", report.Result)
+		}
+		if report.PeakMemory != nil {
+			record.Set("peakMemory", *report.PeakMemory)
+		}
+		if report.CPUTime != nil {
+			record.Set("cpuTime", *report.CPUTime)
+		}
+
+		if isJob && len(report.Logs) > 0 {
+			logs := newJobLogWriter(app, record)
+			for _, line := range report.Logs {
+				logs.add(line.Level, line.Message)
+			}
+			if err := logs.flush(); err != nil {
+				app.Logger().Error("❌ worker logs flush failed", "id", record.Id, "err", err)
+			}
+		}
+		if report.LogsText != "" {
+			record.Set("logs", record.GetString("logs")+report.LogsText)
+		}
+
+		final := isTaskDone(report.Status)
+		expires := workerLeaseExpires()
+
+		if final {
+			record.Set("status", report.Status)
+			record.Set("error", report.Error)
+			if isJob {
+				record.Set("errorCode", report.ErrorCode)
+			}
+			record.Set("worker", "")
+			record.Set("leaseExpires", nil)
+		} else {
+			record.Set("leaseExpires", expires)
+		}
+
+		if err := app.Save(record); err != nil {
+			app.Logger().Error("❌ worker report failed", "id", record.Id, "err", err)
+			return e.JSON(500, errorJSON("Failed to save report"))
+		}
+
+		if final {
+			app.Logger().Info("📥 worker task done", "collection", record.Collection().Name, "id", record.Id, "status", report.Status)
+
+			if isJob {
+				if report.Status == "failed" {
+					retryJob(app, record)
+				}
+				jobQueue.done(record.Id)
+			}
+
+			return e.JSON(200, map[string]any{"cancel": false})
+		}
+
+		return e.JSON(200, map[string]any{
+			"cancel":  isJob && jobQueue.isCancelled(record.Id),
+			"expires": expires.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// uploadWorkerFile reçoit un fichier produit par le worker :
+// fichier du job (champ outputs), media créé dans le groupe du job (media=true) ou sortie d'un transcode
+func uploadWorkerFile(e *core.RequestEvent) error {
+	app := e.App
+
+	record, err := leasedRecord(e)
+	if err != nil {
+		return err
+	}
+
+	files, err := e.FindUploadedFiles("file")
+	if err != nil || len(files) == 0 {
+		return e.JSON(400, errorJSON("Missing file"))
+	}
+	file := files[0]
+
+	if record.Collection().Name == "transcodes" {
+		// format segmenté : les fichiers gardent leur nom, référencé par les playlists
+		if format := supportedFormats[record.GetString("format")]; format.Manifest != "" {
+			if err := setPackagedFile(record, format, file); err != nil {
+				return e.JSON(400, errorJSON("%s", err.Error()))
+			}
+		} else {
+			record.Set("output", file)
+		}
+	} else if e.Request.FormValue("media") == "true" {
+		media, err := createMedia(app, record.GetString("group"), file)
+		if err != nil {
+			return e.JSON(400, errorJSON("Failed to create media: %s", err.Error()))
+		}
+		return e.JSON(200, media)
+	} else {
+		record.Set("outputs+", file)
+	}
+
+	if err := app.Save(record); err != nil {
+		return e.JSON(400, errorJSON("Failed to save file: %s", err.Error()))
+	}
+
+	return e.JSON(200, map[string]any{"name": file.Name})
+}
+
+// serveWorkerFile envoie au worker un fichier d'entrée : fichier d'un job qu'il exécute,
+// ou fichier source du media d'un transcode qu'il exécute
+func serveWorkerFile(e *core.RequestEvent) error {
+	app := e.App
+	worker := e.Request.Header.Get(workerHeader)
+	collection := e.Request.PathValue("collection")
+	id := e.Request.PathValue("id")
+	name := e.Request.PathValue("name")
+
+	var record *core.Record
+	var err error
+
+	switch collection {
+	case "jobs":
+		record, err = app.FindFirstRecordByFilter("jobs",
+			"id = {:id} && status = 'processing' && worker = {:worker}",
+			map[string]any{"id": id, "worker": worker})
+		if err == nil && !containsString(record.GetStringSlice("files"), name) {
+			err = errors.New("file not found")
+		}
+	case "medias":
+		_, err = app.FindFirstRecordByFilter("transcodes",
+			"media = {:id} && status = 'processing' && worker = {:worker}",
+			map[string]any{"id": id, "worker": worker})
+		if err == nil {
+			record, err = app.FindRecordById("medias", id)
+		}
+		if err == nil && record.GetString("file") != name {
+			err = errors.New("file not found")
+		}
+	default:
+		err = errors.New("unknown collection")
+	}
+	if err != nil {
+		return e.JSON(404, errorJSON("File not found"))
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return e.JSON(500, errorJSON("Filesystem error"))
+	}
+	defer fsys.Close()
+
+	return fsys.Serve(e.Response, e.Request, record.BaseFilesPath()+"/"+name, name)
+}
+
+// expireWorkerLeases remet en attente les tâches dont le worker n'a plus donné de nouvelles
+func expireWorkerLeases(app *pocketbase.PocketBase) {
+	now := time.Now().UTC().Format(types.DefaultDateLayout)
+
+	for _, collection := range []string{"jobs", "transcodes"} {
+		records, err := app.FindRecordsByFilter(
+			collection,
+			"status = 'processing' && worker != '' && leaseExpires != '' && leaseExpires < {:now}",
+			"",
+			0,
+			0,
+			map[string]any{"now": now},
+		)
+		if err != nil {
+			app.Logger().Error("❌ failed to load expired leases", "collection", collection, "err", err)
+			continue
+		}
+
+		for _, record := range records {
+			releaseLease(app, record, "lease expired")
+		}
+	}
+}
+
+// bindWorkerLeases expose aux workers distants (commande worker) l'attribution des jobs et transcodes par bail,
+// le heartbeat et l'échange des fichiers. Les baux expirés sont remis en file chaque minute.
+func bindWorkerLeases(app *pocketbase.PocketBase) {
+	app.Cron().MustAdd("workerLeases", "* * * * *", func() {
+		expireWorkerLeases(app)
+	})
+
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		workers := se.Router.Group("/api/workers")
+		workers.BindFunc(requireWorker)
+
+		workers.POST("/lease", leaseWorkerTask(app)).Bind(apis.SkipSuccessActivityLog())
+		workers.POST("/{collection}/{id}/heartbeat", reportWorkerTask(app)).Bind(apis.SkipSuccessActivityLog())
+		workers.POST("/{collection}/{id}/files", uploadWorkerFile).
+			Bind(apis.BodyLimit(int64(envInt("WORKER_MAX_UPLOAD", defaultWorkerMaxUpload))))
+		workers.GET("/files/{collection}/{id}/{name}", serveWorkerFile)
+
+		return se.Next()
+	})
+}
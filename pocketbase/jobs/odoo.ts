@@ -14,12 +14,26 @@ initConsole();
 
 await adminLogin();
 
-const odooCredential: OdooCredential = await contentColl.findOne({
-  type: "odoo",
-  group,
-  data: ["!=", null],
-}).then((c) => c?.data);
-if (!odooCredential) throw new Error("no odoo credential");
+// identifiants Odoo : secrets du groupe (ODOO_URL, ODOO_DB, ODOO_LOGIN, ODOO_PASSWORD)
+const odooCredential: OdooCredential = {
+  url: Deno.env.get("ODOO_URL") || "",
+  db: Deno.env.get("ODOO_DB") || "",
+  login: Deno.env.get("ODOO_LOGIN") || "",
+  password: Deno.env.get("ODOO_PASSWORD") || "",
+};
+if (!odooCredential.url || !odooCredential.login || !odooCredential.password) {
+  // ancien stockage (content "odoo" du groupe), migré vers les secrets au démarrage du serveur
+  const legacy: OdooCredential | undefined = await contentColl.findOne({
+    type: "odoo",
+    group,
+    data: ["!=", null],
+  }).then((c) => c?.data);
+  if (!legacy) {
+    throw new Error("no odoo credential (group secrets ODOO_URL, ODOO_DB, ODOO_LOGIN, ODOO_PASSWORD)");
+  }
+  console.warn("deprecated: odoo credential read from contents, move it to the group secrets ODOO_*");
+  Object.assign(odooCredential, legacy);
+}
 
 const odoo = new Odoo(odooCredential, group);
 
@@ -4,6 +4,12 @@ import { toErr } from "../../common/helpers/err.ts";
 export const job: JobModel = JSON.parse(Deno.args[0]);
 export const pbAuth = JSON.parse(Deno.args[0]);
 
+/** Dossier de travail privé du job (les fichiers produits doivent y être écrits) */
+export const jobDir: string = Deno.env.get('JOB_DIR') || '';
+
+/** Chemins locaux des fichiers du job (champ files) */
+export const inputFiles: string[] = JSON.parse(Deno.env.get('JOB_INPUT_FILES') || '[]');
+
 const stringify = (arg: any) => {
     try {
         return (
@@ -25,6 +31,12 @@ export const setProgress = (progress: number) => send("progress", progress);
 
 export const setResult = (result: any) => send("result", result);
 
+/** Attache un fichier (chemin dans jobDir) au champ outputs du job */
+export const addFile = (path: string) => send("file", path);
+
+/** Crée un media dans le groupe du job à partir d'un fichier (chemin dans jobDir) */
+export const addMedia = (path: string) => send("file", path, "media");
+
 export const initConsole = () => {
     Object.assign(console, {
         debug: (...args: any[]) => send('D', ...args),